@@ -2,137 +2,290 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"math/rand"
+	"log/slog"
 	"os"
-	"os/exec"
 	"os/signal"
-	"strings"
-	"time"
+	"sync"
 
 	"github.com/caarlos0/env"
+	"github.com/kevinkjt2000/twitch-go-bot/commands"
+	"github.com/kevinkjt2000/twitch-go-bot/eventsub"
+	"github.com/kevinkjt2000/twitch-go-bot/mpris"
+	"github.com/kevinkjt2000/twitch-go-bot/rewards"
+	"github.com/kevinkjt2000/twitch-go-bot/tts"
 	"github.com/kevinkjt2000/twitch-go-bot/twitch"
-	"nhooyr.io/websocket"
 )
 
-func main() {
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+type botConfig struct {
+	ConfigFile string `env:"BOT_CONFIG_FILE" envDefault:"config.json"`
+	// LogLevel is debug, info, warn, or error.
+	LogLevel string `env:"BOT_LOG_LEVEL" envDefault:"info"`
+	// LogFormat is text or json.
+	LogFormat string `env:"BOT_LOG_FORMAT" envDefault:"text"`
+}
 
-	var conf twitch.Config
-	err := env.Parse(&conf)
-	panicOnErr(err)
-	client, err := twitch.NewClient(ctx, conf)
-	panicOnErr(err)
-	defer client.Close()
+func newLogger(conf botConfig) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(conf.LogLevel)); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch conf.LogFormat {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", conf.LogFormat)
+	}
+}
 
-	broadcasterId, err := client.GetBroadcasterId("shinybucket_")
-	panicOnErr(err)
-	conn, _, err := websocket.Dial(ctx, "wss://eventsub.wss.twitch.tv/ws", nil)
-	panicOnErr(err)
-	defer conn.CloseNow()
-	defer conn.Close(websocket.StatusNormalClosure, "")
+// Exit codes, following sysexits.h so that service managers can be told not
+// to restart after failures that need someone to step in.
+const (
+	exitOK     = 0
+	exitAuth   = 77 // EX_NOPERM
+	exitConfig = 78 // EX_CONFIG
+)
 
-	twitchMessages := twitchMessagesChannel(conn, ctx)
-	keepAliveTimeoutSeconds := float64(15)
-	for {
-		select {
-		case <-interrupt:
-			fmt.Println("Interrupt detected!")
-			return
-		case <-time.After(time.Duration(keepAliveTimeoutSeconds) * time.Second):
-			fmt.Printf("%v seconds passed without any message\n", keepAliveTimeoutSeconds)
-			return
-		case tMsg := <-twitchMessages:
-			switch tMsg.Metadata.MessageType {
-			case "session_welcome":
-				session := tMsg.Payload["session"].(map[string]interface{})
-				sessionId := session["id"].(string)
-				err = client.SubscribeToEvent(broadcasterId, sessionId)
-				panicOnErr(err)
-				keepAliveTimeoutSeconds = session["keepalive_timeout_seconds"].(float64) * 2 // wait 2 durations to be safe
-			case "notification":
-				switch tMsg.Metadata.SubscriptionType {
-				case "channel.channel_points_custom_reward_redemption.add":
-					event := tMsg.Payload["event"].(map[string]interface{})
-					reward := event["reward"].(map[string]interface{})
-					switch reward["title"] {
-					case "TTS":
-						fmt.Printf("TTS event: %v\n", event)
-						msg := event["user_input"].(string)
-						_ = speak(msg) // TODO: refund user if festival fails
-						// TODO: pause music?
-					default: // Can safely ignore rewards that do not require an automated response
-					}
-					fmt.Printf("%s redeemed '%s'\n", event["user_login"], reward["title"])
-				default:
-					fmt.Printf("unimplemented subscription handle: %s", tMsg.Metadata.SubscriptionType)
-				}
-			case "session_keepalive":
-			case "session_reconnect":
-				fmt.Printf("session_reconnect: %s", tMsg)
-				client.Reconnect()
-			default:
-				fmt.Printf("Unhandled twitch message: %s\n", tMsg)
-			}
-			// TODO: handle disconnects
-		}
-	}
+func main() {
+	os.Exit(run())
 }
 
-func twitchMessagesChannel(conn *websocket.Conn, ctx context.Context) chan twitch.Message {
-	twitchMessages := make(chan twitch.Message)
+func run() int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	// a second interrupt kills the bot if shutting down gets stuck
+	context.AfterFunc(ctx, stop)
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				fmt.Println("Closing twitch messages channel")
-				close(twitchMessages)
-				return
-			default:
-				_, data, err := conn.Read(ctx) // the first return value is always "MessageText"
-				if err != nil {
-					continue
-				}
-				var tMsg twitch.Message
-				err = json.Unmarshal(data, &tMsg)
-				if err != nil {
-					continue
-				}
-				twitchMessages <- tMsg
-			}
+	var botConf botConfig
+	if err := env.Parse(&botConf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	logger, err := newLogger(botConf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	conf, err := twitch.LoadConfig(botConf.ConfigFile)
+	if err != nil {
+		logger.Error("Invalid config", "err", err)
+		return exitConfig
+	}
+	ttsConf, err := tts.LoadConfig(botConf.ConfigFile)
+	if err != nil {
+		logger.Error("Invalid TTS config", "err", err)
+		return exitConfig
+	}
+	tokens, err := twitch.NewTokenStore(conf)
+	if err != nil {
+		logger.Error("Invalid token store", "err", err)
+		return exitConfig
+	}
+	b := &bot{
+		conf:          conf,
+		tokens:        tokens,
+		ttsConf:       ttsConf,
+		logger:        logger,
+		defaultVoices: map[string]string{},
+		registries:    map[string]*commands.Registry{},
+	}
+	ttsEngine, err := tts.NewEngine(ttsConf)
+	if err == nil {
+		err = b.setupTTS(ctx, ttsEngine)
+	}
+	if err != nil {
+		logger.Warn("TTS disabled", "err", err)
+	}
+	if b.ttsQueue != nil {
+		go b.ttsQueue.Run(ctx)
+	}
+	for _, channel := range conf.Channels {
+		registry := commands.NewRegistry()
+		registerTTSCommands(registry, b.ttsQueue, b.ttsVoices)
+		if err := registry.LoadFile(channel.CommandsFile); err != nil {
+			logger.Error("Invalid commands file", "channel", channel.Name, "err", err)
+			return exitConfig
 		}
-	}()
-
-	return twitchMessages
+		b.registries[channel.Name] = registry
+	}
+	return supervise(ctx, logger, b.run)
 }
 
-var festivalVoices []string
+// bot holds what outlives restarts: configuration, commands, and the TTS
+// queue, so queued messages survive a reconnect to Twitch.
+type bot struct {
+	conf    twitch.Config
+	ttsConf tts.Config
+	logger  *slog.Logger
+	tokens  twitch.TokenStore
 
-func init() {
-	cmd := exec.Command("ls", "-1", "/usr/share/festival/voices/us")
-	output, err := cmd.CombinedOutput()
-	panicOnErr(err)
-	festivalVoices = strings.Split(string(output), "\n")
-	fmt.Println(festivalVoices)
-	festivalVoices = festivalVoices[:len(festivalVoices)-1]
-	fmt.Println(festivalVoices)
+	ttsQueue      *tts.Queue
+	ttsVoices     tts.Voices
+	defaultVoices map[string]string
+	registries    map[string]*commands.Registry
 }
 
-func speak(msg string) error {
-	randVoice := festivalVoices[rand.Intn(len(festivalVoices))]
-	fmt.Println("using random voice ", randVoice)
-	cmd := exec.Command("festival", "--batch", fmt.Sprintf(`(voice_%s)`, randVoice), fmt.Sprintf(`(SayText "%s")`, msg))
-	return cmd.Start()
+func (b *bot) setupTTS(ctx context.Context, engine tts.Engine) error {
+	voices, err := engine.Voices()
+	if err != nil {
+		return err
+	}
+	b.logger.Info("TTS enabled", "engine", engine.Name(), "voices", len(voices))
+	b.logger.Debug("TTS voices", "voices", voices)
+	b.ttsVoices = tts.NewVoices(voices)
+	for _, channel := range b.conf.Channels {
+		if channel.TTSVoice == "" {
+			continue
+		}
+		voice, ok := b.ttsVoices.Find(channel.TTSVoice)
+		if !ok {
+			b.logger.Warn("Default TTS voice is not installed, using random voices", "channel", channel.Name, "voice", channel.TTSVoice)
+			continue
+		}
+		b.defaultVoices[channel.Name] = voice
+	}
+	var ducker tts.Ducker
+	if b.ttsConf.Duck != "" {
+		mprisDucker, err := mpris.Connect(b.ttsConf.Duck, b.ttsConf.DuckVolume)
+		if err != nil {
+			b.logger.Warn("Not ducking music during TTS", "err", err)
+		} else {
+			go func() {
+				<-ctx.Done()
+				mprisDucker.Close()
+			}()
+			ducker = mprisDucker
+		}
+	}
+	b.ttsQueue = tts.NewQueue(tts.NewSpeaker(engine, b.ttsConf, ducker, b.logger).Speak, b.ttsConf.MaxLength, b.ttsConf.MaxDuration())
+	return nil
 }
 
-func panicOnErr(err error) {
+// run connects to Twitch and handles events until ctx is done or the
+// connection fails.
+func (b *bot) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	logger := b.logger
+
+	client, err := twitch.NewClient(ctx, b.conf, b.tokens, b.registries, logger)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	defer client.Close()
+
+	broadcasterIds := map[string]string{}
+	for _, channel := range b.conf.Channels {
+		broadcasterIds[channel.Name], err = client.GetBroadcasterId(ctx, channel.Name)
+		if err != nil {
+			return err
+		}
+	}
+	dispatcher := rewards.NewDispatcher(client, client)
+	if b.ttsQueue != nil {
+		dispatcher.HandleTitle("TTS", ttsRewardHandler(client, logger, b.ttsQueue, tts.NewSanitizer(b.ttsConf.BannedWords), b.ttsVoices, b.defaultVoices))
+	}
+	// redemptions in progress are canceled along with ctx, and waited for so
+	// that they can still be refunded
+	var redemptions sync.WaitGroup
+	defer func() {
+		cancel()
+		redemptions.Wait()
+	}()
+	// each broadcaster's subscriptions get their own EventSub session, since
+	// they are created with that broadcaster's token. A session only stops
+	// by itself when Twitch rejects a subscription, which restarting would
+	// not fix, so the other channels and chat keep going without it.
+	events := make(chan eventsub.Message)
+	type eventsubStop struct {
+		channel string
+		err     error
+	}
+	eventsubStopped := make(chan eventsubStop, len(b.conf.Channels))
+	for _, channel := range b.conf.Channels {
+		if len(channel.Subscriptions) == 0 {
+			continue
+		}
+		channel := channel
+		eventsubClient := eventsub.NewClient(b.conf.EventSubURL, func(ctx context.Context, sessionId string) error {
+			for _, subscriptionType := range channel.Subscriptions {
+				if err := client.SubscribeToEvent(ctx, subscriptionType, broadcasterIds[channel.Name], sessionId); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, logger.With("channel", channel.Name))
+		go func() {
+			eventsubStopped <- eventsubStop{channel.Name, eventsubClient.Run(ctx)}
+		}()
+		go func() {
+			for msg := range eventsubClient.Events() {
+				select {
+				case events <- msg:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Disconnecting from Twitch", "chat", client.ChatStats())
+			return ctx.Err()
+		case stopped := <-eventsubStopped:
+			if ctx.Err() == nil {
+				logger.Error("Stopped receiving events until the bot restarts", "channel", stopped.channel, "err", stopped.err)
+			}
+		case tMsg := <-events:
+			switch msg := tMsg.(type) {
+			case *eventsub.NotificationMessage:
+				eventLogger := logger.With("subscription_type", msg.Metadata.SubscriptionType, "message_id", msg.Metadata.MessageId)
+				switch event := msg.Event.(type) {
+				case *eventsub.ChannelPointsRedemptionEvent:
+					eventLogger.Info("Reward redeemed", "channel", event.BroadcasterUserLogin, "user", event.UserLogin, "reward", event.Reward.Title)
+					redemptions.Add(1)
+					go func() {
+						defer redemptions.Done()
+						if err := dispatcher.Dispatch(ctx, event); err != nil {
+							eventLogger.Error("Failed to handle redemption", "channel", event.BroadcasterUserLogin, "user", event.UserLogin, "reward", event.Reward.Title, "err", err)
+						}
+					}()
+				case *eventsub.FollowEvent:
+					eventLogger.Info("Followed", "channel", event.BroadcasterUserLogin, "user", event.UserLogin)
+				case *eventsub.SubscribeEvent:
+					eventLogger.Info("Subscribed", "channel", event.BroadcasterUserLogin, "user", event.UserLogin, "tier", event.Tier)
+				case *eventsub.SubscriptionMessageEvent:
+					eventLogger.Info("Resubscribed", "channel", event.BroadcasterUserLogin, "user", event.UserLogin, "months", event.CumulativeMonths)
+				case *eventsub.SubscriptionGiftEvent:
+					eventLogger.Info("Gifted subs", "channel", event.BroadcasterUserLogin, "user", event.UserLogin, "total", event.Total)
+				case *eventsub.CheerEvent:
+					eventLogger.Info("Cheered", "channel", event.BroadcasterUserLogin, "user", event.UserLogin, "bits", event.Bits)
+				case *eventsub.RaidEvent:
+					eventLogger.Info("Raided", "channel", event.ToBroadcasterUserLogin, "user", event.FromBroadcasterUserLogin, "viewers", event.Viewers)
+				case *eventsub.StreamOnlineEvent:
+					eventLogger.Info("Went live", "channel", event.BroadcasterUserLogin)
+				case *eventsub.StreamOfflineEvent:
+					eventLogger.Info("Went offline", "channel", event.BroadcasterUserLogin)
+				case *eventsub.PollEvent:
+					eventLogger.Info("Poll", "channel", event.BroadcasterUserLogin, "title", event.Title)
+				case *eventsub.PredictionEvent:
+					eventLogger.Info("Prediction", "channel", event.BroadcasterUserLogin, "title", event.Title)
+				case *eventsub.HypeTrainEvent:
+					eventLogger.Info("Hype train", "channel", event.BroadcasterUserLogin, "level", event.Level)
+				case *eventsub.AdBreakEvent:
+					eventLogger.Info("Ad break", "channel", event.BroadcasterUserLogin, "seconds", event.DurationSeconds)
+				default:
+					eventLogger.Warn("Unhandled subscription type")
+				}
+			case *eventsub.RevocationMessage:
+				logger.Warn("Subscription revoked", "subscription_type", msg.Subscription.Type, "status", msg.Subscription.Status, "message_id", msg.Metadata.MessageId)
+			default:
+				logger.Warn("Unhandled EventSub message", "message_type", tMsg.Meta().MessageType, "message_id", tMsg.Meta().MessageId)
+			}
+		}
 	}
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/kevinkjt2000/twitch-go-bot/twitch"
+)
+
+const (
+	minRestartBackoff = time.Second
+	maxRestartBackoff = 2 * time.Minute
+)
+
+// supervise runs the bot until ctx is done, restarting it with backoff
+// after failures that may go away on their own, such as network errors and
+// Twitch outages. It returns the exit code for the process.
+func supervise(ctx context.Context, logger *slog.Logger, run func(ctx context.Context) error) int {
+	backoff := minRestartBackoff
+	for {
+		started := time.Now()
+		err := run(ctx)
+		if ctx.Err() != nil {
+			logger.Info("Shut down")
+			return exitOK
+		}
+		if code, fatal := exitCode(err); fatal {
+			logger.Error("Giving up", "err", err, "exit_code", code)
+			return code
+		}
+		if time.Since(started) > maxRestartBackoff {
+			backoff = minRestartBackoff // it was healthy for a while
+		}
+		logger.Warn("Bot stopped, restarting", "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			logger.Info("Shut down")
+			return exitOK
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+	}
+}
+
+// exitCode picks the exit code for errors that restarting cannot fix.
+// Errors from the Twitch API, even 401 and 403, are not among them: a
+// restart validates every token again, renewing invalid ones, and only
+// fails with twitch.ErrAuth if that does not help.
+func exitCode(err error) (code int, fatal bool) {
+	if errors.Is(err, twitch.ErrAuth) {
+		return exitAuth, true
+	}
+	if errors.Is(err, twitch.ErrUnknownUser) || errors.Is(err, twitch.ErrPassphrase) || errors.Is(err, twitch.ErrReadOnlyStore) {
+		return exitConfig, true
+	}
+	return 0, false
+}
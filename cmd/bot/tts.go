@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kevinkjt2000/twitch-go-bot/commands"
+	"github.com/kevinkjt2000/twitch-go-bot/eventsub"
+	"github.com/kevinkjt2000/twitch-go-bot/rewards"
+	"github.com/kevinkjt2000/twitch-go-bot/tts"
+	"github.com/kevinkjt2000/twitch-go-bot/twitch"
+)
+
+// ttsRewardHandler queues the redemption's sanitized message, refunding it if
+// it cannot be played. Messages skipped by moderators are not refunded.
+// When ctx is done, a message still waiting is taken out of the queue and
+// refunded, while one already playing is waited for and fulfilled, as the
+// queue outlives the connection to Twitch.
+// Viewers may pick a voice with a tag like "[kal] hello"; otherwise the
+// channel's default voice from defaultVoices is used.
+func ttsRewardHandler(client twitch.Client, logger *slog.Logger, queue *tts.Queue, sanitizer *tts.Sanitizer, voices tts.Voices, defaultVoices map[string]string) rewards.RewardHandler {
+	return rewards.HandlerFunc(func(ctx context.Context, redemption *eventsub.ChannelPointsRedemptionEvent) error {
+		refund := func(err error) error {
+			return &rewards.RefundError{Reason: ttsFailureReason(err), Err: err}
+		}
+		voice, text, err := voices.Choose(redemption.UserInput, defaultVoices[redemption.BroadcasterUserLogin])
+		if err != nil {
+			return refund(err)
+		}
+		text = sanitizer.Sanitize(text)
+		if text == "" {
+			return refund(errNothingToSay)
+		}
+		logger.Debug("Queueing TTS message", "channel", redemption.BroadcasterUserLogin, "user", redemption.UserLogin, "voice", voice)
+		position, done, err := queue.Enqueue(ctx, redemption.BroadcasterUserLogin, voice, text)
+		if err != nil {
+			return refund(err)
+		}
+		if position > 1 {
+			client.Say(redemption.BroadcasterUserLogin, fmt.Sprintf(
+				"@%s your TTS message is #%d in the queue.", redemption.UserLogin, position))
+		}
+		err = <-done
+		if errors.Is(err, tts.ErrSkipped) {
+			return nil
+		}
+		if err != nil {
+			return refund(err)
+		}
+		return nil
+	})
+}
+
+// registerTTSCommands provides the skiptts, cleartts, ttsqueue, and voices
+// handlers for use in commands files. They reply that TTS is disabled when
+// queue is nil.
+func registerTTSCommands(registry *commands.Registry, queue *tts.Queue, voices tts.Voices) {
+	if queue == nil {
+		for _, name := range []string{"skiptts", "cleartts", "ttsqueue", "voices"} {
+			registry.RegisterHandler(name, func(commands.Context) (string, error) {
+				return "TTS is disabled.", nil
+			})
+		}
+		return
+	}
+	registry.RegisterHandler("skiptts", func(ctx commands.Context) (string, error) {
+		if !queue.Skip(ctx.Channel) {
+			return "No TTS message from this channel is playing.", nil
+		}
+		return "Skipped the current TTS message.", nil
+	})
+	registry.RegisterHandler("cleartts", func(ctx commands.Context) (string, error) {
+		return fmt.Sprintf("Cleared %d TTS messages.", queue.Clear(ctx.Channel)), nil
+	})
+	registry.RegisterHandler("ttsqueue", func(ctx commands.Context) (string, error) {
+		return fmt.Sprintf("There are %d TTS messages in the queue.", queue.Len(ctx.Channel)), nil
+	})
+	registry.RegisterHandler("voices", func(commands.Context) (string, error) {
+		const prefix = "Start a TTS message with [voice] to pick one of: "
+		var response strings.Builder
+		response.WriteString(prefix)
+		for i, voice := range voices.List() {
+			separator := ", "
+			if i == 0 {
+				separator = ""
+			}
+			// leave room for ", ..." in case more voices follow
+			if utf8.RuneCountInString(response.String()+separator+voice) > maxChatLength-len(", ...") {
+				response.WriteString(", ...")
+				break
+			}
+			response.WriteString(separator + voice)
+		}
+		return response.String(), nil
+	})
+}
+
+// maxChatLength is the most characters Twitch allows in a chat message.
+const maxChatLength = 500
+
+var errNothingToSay = errors.New("nothing to say")
+
+// ttsFailureReason explains a speak error to chat.
+func ttsFailureReason(err error) string {
+	switch {
+	case errors.Is(err, errNothingToSay):
+		return "there was nothing left to say after removing links and banned words"
+	case errors.Is(err, tts.ErrUnknownVoice):
+		return "that voice does not exist (see !voices)"
+	case errors.Is(err, tts.ErrTooLong):
+		return "your TTS message is too long"
+	case errors.Is(err, tts.ErrVoiceMissing):
+		return "the TTS voice is not installed"
+	case errors.Is(err, context.Canceled):
+		return "the bot disconnected before your TTS message played"
+	case errors.Is(err, tts.ErrTimeout):
+		return "your TTS message took too long to prepare"
+	default:
+		return "TTS could not play your message"
+	}
+}
@@ -73,13 +73,13 @@ public_cameras() {
 
 // You are viewing the Security Booth™ of the public Hive Optimization Run. These are various camera positions showing 2 minutes at a time from the actual server. If you want to join, checkout the information on Diddy's discord https://discord.gg/diddyshive
 
-func getGtWindow() string {
+func getGtWindow() (string, error) {
 	cmd := exec.Command("xdotool", "search", "--name", "GT:")
 	stdout, err := cmd.Output()
 	if err != nil {
-		panic(err)
+		return "", fmt.Errorf("finding the GT: window with xdotool: %w", err)
 	}
-	return strings.TrimSpace(string(stdout))
+	return strings.TrimSpace(string(stdout)), nil
 }
 
 func antiIdle(gtWindow string) {
@@ -133,7 +133,11 @@ func loopCameras(ctx context.Context, gtWindow string, cameras []Camera) {
 }
 
 func main() {
-	gtWindow := getGtWindow()
+	gtWindow, err := getGtWindow()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	setupCameras(gtWindow, hiveCameras)
 
 	interrupt := make(chan os.Signal, 1)
@@ -0,0 +1,113 @@
+// Command faketwitch runs the twitchtest fake Twitch so the bot can be tried
+// out without network access. Type commands on stdin to script Twitch:
+//
+//	chat <channel> <user> <text...>
+//	redeem <reward title> <input...>
+//	reconnect
+//	drop
+//	expire
+//	revoke
+//	authorize <login...>
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/kevinkjt2000/twitch-go-bot/eventsub"
+	"github.com/kevinkjt2000/twitch-go-bot/twitch/twitchtest"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AddUser("1", "shinybucket_")
+	server.AddUser("2", "shinybotwatch")
+	// the bot authorizes its own token first, then the broadcaster's
+	server.AuthorizeAs("shinybotwatch", "shinybucket_")
+
+	conf := server.Config()
+	fmt.Println("Point the bot at this server with:")
+	fmt.Printf("export TWITCH_CLIENT_ID=%s\n", conf.ClientId)
+	fmt.Printf("export TWITCH_CLIENT_SECRET=%s\n", conf.ClientSecret)
+	fmt.Printf("export TWITCH_AUTH_URL=%s\n", conf.AuthURL)
+	fmt.Printf("export TWITCH_HELIX_URL=%s\n", conf.HelixURL)
+	fmt.Printf("export TWITCH_IRC_URL=%s\n", conf.IRCURL)
+	fmt.Printf("export TWITCH_EVENTSUB_URL=%s\n", conf.EventSubURL)
+	fmt.Println("export TWITCH_BOT_LOGIN=shinybotwatch")
+
+	go func() {
+		for {
+			msg, err := server.NextChat(ctx)
+			if err != nil {
+				return
+			}
+			fmt.Printf("#%s <bot> %s\n", msg.Channel, msg.Text)
+		}
+	}()
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line, ok := <-lines:
+			if !ok {
+				<-ctx.Done()
+				return
+			}
+			if err := run(ctx, server, strings.Fields(line)); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+func run(ctx context.Context, server *twitchtest.Server, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	switch {
+	case args[0] == "chat" && len(args) >= 4:
+		return server.SendChat(ctx, args[1], args[2], strings.Join(args[3:], " "))
+	case args[0] == "redeem" && len(args) >= 2:
+		return server.SendNotification(ctx, eventsub.ChannelPointsRedemptionAdd, map[string]interface{}{
+			"id":                     "redemption-1",
+			"broadcaster_user_id":    "1",
+			"broadcaster_user_login": "shinybucket_",
+			"user_id":                "3",
+			"user_login":             "viewer",
+			"user_input":             strings.Join(args[2:], " "),
+			"reward":                 map[string]interface{}{"id": "reward-1", "title": args[1]},
+		})
+	case args[0] == "reconnect":
+		return server.SendReconnect(ctx)
+	case args[0] == "drop":
+		return server.DropEventSub()
+	case args[0] == "expire":
+		server.ExpireTokens()
+		return nil
+	case args[0] == "revoke":
+		server.RevokeTokens()
+		return nil
+	case args[0] == "authorize" && len(args) >= 2:
+		server.AuthorizeAs(args[1:]...)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+	}
+}
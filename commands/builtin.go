@@ -0,0 +1,13 @@
+package commands
+
+import "math/rand"
+
+func eightBall(Context) (string, error) {
+	messages := []string{
+		"It is certain.", "It is decidely so.",
+		"Without a doubt.", "Yes – definitely.", "You may rely on it.", "As I see it, yes.", "Most likely.", "Outlook good.", "Yes.", "Signs point to yes.",
+		"Reply hazy, try again.", "Ask again later.", "Better not tell you now.", "Cannot predict now.", "Concentrate and ask again.",
+		"Don’t count on it.", "My reply is no.", "My sources say no.", "Outlook not so good.", "Very doubtful.",
+	}
+	return messages[rand.Intn(len(messages))], nil
+}
@@ -0,0 +1,181 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Context describes the chat message that invoked a command.
+type Context struct {
+	Channel string
+	User    string
+	UserId  string
+	Badges  []string
+	Args    []string
+}
+
+// HasBadge reports whether the chatter wears the named badge, e.g. "moderator".
+func (c Context) HasBadge(name string) bool {
+	for _, badge := range c.Badges {
+		if badge == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAnyBadge reports whether the chatter wears at least one of names.
+func (c Context) HasAnyBadge(names []string) bool {
+	for _, name := range names {
+		if c.HasBadge(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandlerFunc produces a response for a command invocation.
+// An empty response means nothing is said in chat.
+type HandlerFunc func(ctx Context) (string, error)
+
+// Command is a single chat command such as !discord.
+// Either Response or HandlerName must be set; Response is a text/template
+// rendered with the invoking Context.
+// Cooldown applies to everyone in chat and UserCooldown to each chatter,
+// except for those wearing one of the BypassBadges.
+// When RequiredBadges is set, only chatters wearing one of them may use it.
+type Command struct {
+	Name         string   `json:"name"`
+	Aliases      []string `json:"aliases,omitempty"`
+	Response     string   `json:"response,omitempty"`
+	HandlerName  string   `json:"handler,omitempty"`
+	Cooldown     Duration `json:"cooldown,omitempty"`
+	UserCooldown Duration `json:"user_cooldown,omitempty"`
+	BypassBadges []string `json:"bypass_badges,omitempty"`
+
+	RequiredBadges []string `json:"required_badges,omitempty"`
+
+	Handler HandlerFunc `json:"-"`
+}
+
+type Registry struct {
+	mu        sync.RWMutex
+	commands  map[string]*Command
+	handlers  map[string]HandlerFunc
+	cooldowns cooldowns
+}
+
+// NewRegistry returns an empty registry with the builtin handlers available.
+func NewRegistry() *Registry {
+	r := &Registry{
+		commands: map[string]*Command{},
+		handlers: map[string]HandlerFunc{},
+	}
+	r.RegisterHandler("8ball", eightBall)
+	return r
+}
+
+// RegisterHandler makes a handler available to commands by name.
+func (r *Registry) RegisterHandler(name string, handler HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[name] = handler
+}
+
+// Add registers a command under its name and aliases, replacing any existing
+// command with the same name.
+func (r *Registry) Add(cmd Command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.add(cmd)
+}
+
+func (r *Registry) add(cmd Command) error {
+	if cmd.Name == "" {
+		return errors.New("commands: missing command name")
+	}
+	if cmd.Handler == nil {
+		switch {
+		case cmd.HandlerName != "":
+			handler, ok := r.handlers[cmd.HandlerName]
+			if !ok {
+				return fmt.Errorf("commands: !%s uses unknown handler %q", cmd.Name, cmd.HandlerName)
+			}
+			cmd.Handler = handler
+		case cmd.Response != "":
+			tmpl, err := template.New(cmd.Name).Parse(cmd.Response)
+			if err != nil {
+				return fmt.Errorf("commands: !%s: %w", cmd.Name, err)
+			}
+			cmd.Handler = func(ctx Context) (string, error) {
+				var buf bytes.Buffer
+				if err := tmpl.Execute(&buf, ctx); err != nil {
+					return "", err
+				}
+				return buf.String(), nil
+			}
+		default:
+			return fmt.Errorf("commands: !%s has neither a response nor a handler", cmd.Name)
+		}
+	}
+	for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+		r.commands[normalize(name)] = &cmd
+	}
+	return nil
+}
+
+// LoadFile replaces all registered commands with the ones listed in a JSON file.
+func (r *Registry) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cmds []Command
+	if err := json.Unmarshal(data, &cmds); err != nil {
+		return fmt.Errorf("commands: parsing %s: %w", path, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.commands
+	r.commands = map[string]*Command{}
+	for _, cmd := range cmds {
+		if err := r.add(cmd); err != nil {
+			r.commands = previous
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup finds the command invoked by a chat line, if any.
+func (r *Registry) Lookup(msgline string) (*Command, []string, bool) {
+	fields := strings.Fields(msgline)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "!") {
+		return nil, nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cmd, ok := r.commands[normalize(fields[0])]
+	return cmd, fields[1:], ok
+}
+
+// Allow reports whether the chatter in ctx may use cmd and it is off
+// cooldown, and if so starts its cooldowns.
+func (r *Registry) Allow(cmd *Command, ctx Context) bool {
+	if len(cmd.RequiredBadges) > 0 && !ctx.HasAnyBadge(cmd.RequiredBadges) {
+		return false
+	}
+	return r.cooldowns.allow(cmd, ctx, time.Now())
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimPrefix(name, "!"))
+}
@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// DefaultBypassBadges are the chat badges that ignore cooldowns unless a
+// command lists its own.
+var DefaultBypassBadges = []string{"broadcaster", "moderator", "vip"}
+
+// Duration is a time.Duration that is written as "30s" or "5m" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// sweepInterval is how often expired cooldowns are forgotten, so a long
+// stream with many chatters does not keep every one of them around.
+const sweepInterval = time.Minute
+
+type cooldowns struct {
+	mu sync.Mutex
+	// until is when each cooldown ends
+	until     map[cooldownKey]time.Time
+	lastSweep time.Time
+}
+
+type cooldownKey struct {
+	command string
+	userId  string
+}
+
+// allow reports whether cmd may run for ctx, and if so records the use.
+func (c *cooldowns) allow(cmd *Command, ctx Context, now time.Time) bool {
+	bypass := cmd.BypassBadges
+	if bypass == nil {
+		bypass = DefaultBypassBadges
+	}
+	if ctx.HasAnyBadge(bypass) {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.until == nil {
+		c.until = map[cooldownKey]time.Time{}
+	}
+	globalKey := cooldownKey{command: cmd.Name}
+	userKey := cooldownKey{command: cmd.Name, userId: ctx.UserId}
+	if now.Before(c.until[globalKey]) || now.Before(c.until[userKey]) {
+		return false
+	}
+	if now.Sub(c.lastSweep) >= sweepInterval {
+		c.lastSweep = now
+		for key, until := range c.until {
+			if !now.Before(until) {
+				delete(c.until, key)
+			}
+		}
+	}
+	if cmd.Cooldown > 0 {
+		c.until[globalKey] = now.Add(time.Duration(cmd.Cooldown))
+	}
+	if cmd.UserCooldown > 0 {
+		c.until[userKey] = now.Add(time.Duration(cmd.UserCooldown))
+	}
+	return true
+}
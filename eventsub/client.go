@@ -0,0 +1,300 @@
+package eventsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+const (
+	welcomeTimeout = 10 * time.Second
+	minBackoff     = time.Second
+	maxBackoff     = 2 * time.Minute
+	seenMessageIds = 100
+)
+
+var ErrKeepaliveTimeout = errors.New("eventsub: keepalive timeout")
+
+// SubscribeFunc creates the subscriptions for a newly welcomed session.
+// It is called again every time the client has to start a fresh session.
+// Errors with a Temporary method reporting false, such as twitch.HelixError
+// for a rejected subscription, end Run since a fresh session would fail
+// the same way.
+type SubscribeFunc func(ctx context.Context, sessionId string) error
+
+// temporary is implemented by errors that know whether retrying may help.
+type temporary interface {
+	Temporary() bool
+}
+
+// subscribeError is a SubscribeFunc failure.
+type subscribeError struct {
+	err error
+}
+
+func (e *subscribeError) Error() string {
+	return "eventsub: subscribing: " + e.err.Error()
+}
+
+func (e *subscribeError) Unwrap() error {
+	return e.err
+}
+
+// Client owns an EventSub websocket session. It follows session_reconnect
+// messages without dropping subscriptions, and starts a fresh session with
+// backoff when the connection dies or goes quiet past the keepalive timeout.
+type Client struct {
+	url       string
+	subscribe SubscribeFunc
+	events    chan Message
+	logger    *slog.Logger
+
+	seen     map[string]bool
+	seenRing []string
+}
+
+func NewClient(url string, subscribe SubscribeFunc, logger *slog.Logger) *Client {
+	return &Client{
+		url:       url,
+		subscribe: subscribe,
+		events:    make(chan Message, 16),
+		logger:    logger,
+		seen:      map[string]bool{},
+	}
+}
+
+// Events delivers *NotificationMessage and *RevocationMessage values.
+// It is closed when Run returns.
+func (c *Client) Events() <-chan Message {
+	return c.events
+}
+
+// Run keeps a session alive until ctx is done, or until subscribing fails
+// in a way that retrying cannot fix.
+func (c *Client) Run(ctx context.Context) error {
+	defer close(c.events)
+	backoff := minBackoff
+	for {
+		welcomed, err := c.runSession(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var subscribeErr *subscribeError
+		var temp temporary
+		if errors.As(err, &subscribeErr) && errors.As(subscribeErr.err, &temp) && !temp.Temporary() {
+			return err
+		}
+		if welcomed {
+			backoff = minBackoff
+		}
+		c.logger.Warn("EventSub session ended, redialing", "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// runSession dials a fresh session, subscribes, and reads until it fails.
+func (c *Client) runSession(ctx context.Context) (welcomed bool, err error) {
+	current, err := dial(ctx, c.url, c.logger)
+	if err != nil {
+		return false, err
+	}
+	defer func() { current.close() }()
+
+	welcome, err := current.awaitWelcome(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := c.subscribe(ctx, welcome.Session.Id); err != nil {
+		return true, &subscribeError{err}
+	}
+	keepalive := welcome.Session.KeepaliveTimeout() * 2 // wait 2 durations to be safe
+	timer := time.NewTimer(keepalive)
+	defer timer.Stop()
+
+	// During a session_reconnect both connections are read until the new one
+	// is welcomed, as Twitch keeps delivering events on the old one until then.
+	var next *connection
+	defer func() {
+		if next != nil {
+			next.close()
+		}
+	}()
+	for {
+		var nextMessages chan Message
+		if next != nil {
+			nextMessages = next.messages
+		}
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case <-timer.C:
+			return true, ErrKeepaliveTimeout
+		case msg, ok := <-current.messages:
+			if !ok && next != nil {
+				// Twitch closes the old connection once the new one is welcomed,
+				// which may be noticed before reading that welcome.
+				welcome, err := next.awaitWelcome(ctx)
+				if err != nil {
+					return true, err
+				}
+				current.close()
+				current, next = next, nil
+				keepalive = welcome.Session.KeepaliveTimeout() * 2
+				resetTimer(timer, keepalive)
+				continue
+			}
+			if !ok {
+				return true, current.err
+			}
+			resetTimer(timer, keepalive)
+			if reconnect, isReconnect := msg.(*ReconnectMessage); isReconnect {
+				if reconnect.Session.ReconnectUrl == nil {
+					return true, errors.New("eventsub: session_reconnect without a reconnect_url")
+				}
+				if next != nil {
+					next.close()
+				}
+				next, err = dial(ctx, *reconnect.Session.ReconnectUrl, c.logger)
+				if err != nil {
+					return true, err
+				}
+				continue
+			}
+			if err := c.deliver(ctx, msg); err != nil {
+				return true, err
+			}
+		case msg, ok := <-nextMessages:
+			if !ok {
+				return true, next.err
+			}
+			welcome, isWelcome := msg.(*WelcomeMessage)
+			if !isWelcome {
+				return true, fmt.Errorf("eventsub: expected %s on reconnect, got %s", MessageTypeWelcome, msg.Meta().MessageType)
+			}
+			current.close()
+			current, next = next, nil
+			keepalive = welcome.Session.KeepaliveTimeout() * 2
+			resetTimer(timer, keepalive)
+		}
+	}
+}
+
+// deliver passes events along, skipping ones that were already delivered
+// since Twitch may resend a message.
+func (c *Client) deliver(ctx context.Context, msg Message) error {
+	switch msg.(type) {
+	case *NotificationMessage, *RevocationMessage:
+	default:
+		return nil
+	}
+	id := msg.Meta().MessageId
+	if c.seen[id] {
+		return nil
+	}
+	c.seen[id] = true
+	c.seenRing = append(c.seenRing, id)
+	if len(c.seenRing) > seenMessageIds {
+		delete(c.seen, c.seenRing[0])
+		c.seenRing = c.seenRing[1:]
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case c.events <- msg:
+		return nil
+	}
+}
+
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// connection reads decoded messages from one websocket until it fails,
+// at which point messages is closed and err explains why.
+type connection struct {
+	conn     *websocket.Conn
+	cancel   context.CancelFunc
+	messages chan Message
+	err      error
+	logger   *slog.Logger
+}
+
+func dial(ctx context.Context, url string, logger *slog.Logger) (*connection, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	c := &connection{
+		conn:     conn,
+		cancel:   cancel,
+		messages: make(chan Message),
+		logger:   logger,
+	}
+	go c.read(ctx)
+	return c, nil
+}
+
+func (c *connection) read(ctx context.Context) {
+	defer close(c.messages)
+	for {
+		_, data, err := c.conn.Read(ctx)
+		if err != nil {
+			c.err = err
+			return
+		}
+		msg, err := Decode(data)
+		if err != nil {
+			c.logger.Warn("Skipping undecodable EventSub message", "err", err)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			c.err = ctx.Err()
+			return
+		case c.messages <- msg:
+		}
+	}
+}
+
+func (c *connection) awaitWelcome(ctx context.Context) (*WelcomeMessage, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(welcomeTimeout):
+		return nil, errors.New("eventsub: timed out waiting for welcome")
+	case msg, ok := <-c.messages:
+		if !ok {
+			return nil, c.err
+		}
+		welcome, isWelcome := msg.(*WelcomeMessage)
+		if !isWelcome {
+			return nil, fmt.Errorf("eventsub: expected %s, got %s", MessageTypeWelcome, msg.Meta().MessageType)
+		}
+		return welcome, nil
+	}
+}
+
+func (c *connection) close() {
+	c.conn.Close(websocket.StatusNormalClosure, "")
+	c.cancel()
+}
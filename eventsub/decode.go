@@ -0,0 +1,80 @@
+package eventsub
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type envelope struct {
+	Metadata Metadata        `json:"metadata"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+type sessionPayload struct {
+	Session *Session `json:"session"`
+}
+
+type subscriptionPayload struct {
+	Subscription *Subscription   `json:"subscription"`
+	Event        json.RawMessage `json:"event,omitempty"`
+}
+
+// Decode parses a websocket frame into a typed Message.
+func Decode(data []byte) (Message, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("eventsub: decoding message: %w", err)
+	}
+
+	switch env.Metadata.MessageType {
+	case MessageTypeWelcome, MessageTypeReconnect:
+		var payload sessionPayload
+		if err := json.Unmarshal(env.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("eventsub: decoding %s: %w", env.Metadata.MessageType, err)
+		}
+		if payload.Session == nil {
+			return nil, fmt.Errorf("eventsub: %s without a session", env.Metadata.MessageType)
+		}
+		if env.Metadata.MessageType == MessageTypeWelcome {
+			return &WelcomeMessage{Metadata: env.Metadata, Session: *payload.Session}, nil
+		}
+		return &ReconnectMessage{Metadata: env.Metadata, Session: *payload.Session}, nil
+	case MessageTypeKeepalive:
+		return &KeepaliveMessage{Metadata: env.Metadata}, nil
+	case MessageTypeRevocation, MessageTypeNotification:
+		var payload subscriptionPayload
+		if err := json.Unmarshal(env.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("eventsub: decoding %s: %w", env.Metadata.MessageType, err)
+		}
+		if payload.Subscription == nil {
+			return nil, fmt.Errorf("eventsub: %s without a subscription", env.Metadata.MessageType)
+		}
+		if env.Metadata.MessageType == MessageTypeRevocation {
+			return &RevocationMessage{Metadata: env.Metadata, Subscription: *payload.Subscription}, nil
+		}
+		event, err := decodeEvent(env.Metadata.SubscriptionType, payload.Event)
+		if err != nil {
+			return nil, err
+		}
+		return &NotificationMessage{
+			Metadata:     env.Metadata,
+			Subscription: *payload.Subscription,
+			Event:        event,
+			RawEvent:     payload.Event,
+		}, nil
+	default:
+		return nil, fmt.Errorf("eventsub: unknown message type %q", env.Metadata.MessageType)
+	}
+}
+
+func decodeEvent(subscriptionType string, data json.RawMessage) (interface{}, error) {
+	newEvent, ok := eventTypes[subscriptionType]
+	if !ok {
+		return nil, nil
+	}
+	event := newEvent()
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, fmt.Errorf("eventsub: decoding %s event: %w", subscriptionType, err)
+	}
+	return event, nil
+}
@@ -0,0 +1,218 @@
+package eventsub
+
+import "time"
+
+// Subscription types with typed events.
+// https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types/
+const (
+	ChannelFollow              = "channel.follow"
+	ChannelSubscribe           = "channel.subscribe"
+	ChannelSubscriptionMessage = "channel.subscription.message"
+	ChannelSubscriptionGift    = "channel.subscription.gift"
+	ChannelCheer               = "channel.cheer"
+	ChannelRaid                = "channel.raid"
+	StreamOnline               = "stream.online"
+	StreamOffline              = "stream.offline"
+	ChannelPollBegin           = "channel.poll.begin"
+	ChannelPollProgress        = "channel.poll.progress"
+	ChannelPollEnd             = "channel.poll.end"
+	ChannelPredictionBegin     = "channel.prediction.begin"
+	ChannelPredictionProgress  = "channel.prediction.progress"
+	ChannelPredictionLock      = "channel.prediction.lock"
+	ChannelPredictionEnd       = "channel.prediction.end"
+	ChannelHypeTrainBegin      = "channel.hype_train.begin"
+	ChannelHypeTrainProgress   = "channel.hype_train.progress"
+	ChannelHypeTrainEnd        = "channel.hype_train.end"
+	ChannelAdBreakBegin        = "channel.ad_break.begin"
+	ChannelPointsRedemptionAdd = "channel.channel_points_custom_reward_redemption.add"
+)
+
+// eventTypes creates an empty event struct for each known subscription type.
+var eventTypes = map[string]func() interface{}{
+	ChannelFollow:              func() interface{} { return &FollowEvent{} },
+	ChannelSubscribe:           func() interface{} { return &SubscribeEvent{} },
+	ChannelSubscriptionMessage: func() interface{} { return &SubscriptionMessageEvent{} },
+	ChannelSubscriptionGift:    func() interface{} { return &SubscriptionGiftEvent{} },
+	ChannelCheer:               func() interface{} { return &CheerEvent{} },
+	ChannelRaid:                func() interface{} { return &RaidEvent{} },
+	StreamOnline:               func() interface{} { return &StreamOnlineEvent{} },
+	StreamOffline:              func() interface{} { return &StreamOfflineEvent{} },
+	ChannelPollBegin:           func() interface{} { return &PollEvent{} },
+	ChannelPollProgress:        func() interface{} { return &PollEvent{} },
+	ChannelPollEnd:             func() interface{} { return &PollEvent{} },
+	ChannelPredictionBegin:     func() interface{} { return &PredictionEvent{} },
+	ChannelPredictionProgress:  func() interface{} { return &PredictionEvent{} },
+	ChannelPredictionLock:      func() interface{} { return &PredictionEvent{} },
+	ChannelPredictionEnd:       func() interface{} { return &PredictionEvent{} },
+	ChannelHypeTrainBegin:      func() interface{} { return &HypeTrainEvent{} },
+	ChannelHypeTrainProgress:   func() interface{} { return &HypeTrainEvent{} },
+	ChannelHypeTrainEnd:        func() interface{} { return &HypeTrainEvent{} },
+	ChannelAdBreakBegin:        func() interface{} { return &AdBreakEvent{} },
+	ChannelPointsRedemptionAdd: func() interface{} { return &ChannelPointsRedemptionEvent{} },
+}
+
+// Broadcaster is the channel an event happened in.
+type Broadcaster struct {
+	BroadcasterUserId    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+}
+
+// Chatter is the viewer who caused an event. Its fields are empty for
+// anonymous gifts and cheers.
+type Chatter struct {
+	UserId    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	UserName  string `json:"user_name"`
+}
+
+type FollowEvent struct {
+	Broadcaster
+	Chatter
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type SubscribeEvent struct {
+	Broadcaster
+	Chatter
+	Tier   string `json:"tier"`
+	IsGift bool   `json:"is_gift"`
+}
+
+type SubscriptionMessageEvent struct {
+	Broadcaster
+	Chatter
+	Tier    string `json:"tier"`
+	Message struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	CumulativeMonths int  `json:"cumulative_months"`
+	StreakMonths     *int `json:"streak_months"`
+	DurationMonths   int  `json:"duration_months"`
+}
+
+type SubscriptionGiftEvent struct {
+	Broadcaster
+	Chatter
+	Total           int    `json:"total"`
+	Tier            string `json:"tier"`
+	CumulativeTotal *int   `json:"cumulative_total"`
+	IsAnonymous     bool   `json:"is_anonymous"`
+}
+
+type CheerEvent struct {
+	Broadcaster
+	Chatter
+	IsAnonymous bool   `json:"is_anonymous"`
+	Message     string `json:"message"`
+	Bits        int    `json:"bits"`
+}
+
+type RaidEvent struct {
+	FromBroadcasterUserId    string `json:"from_broadcaster_user_id"`
+	FromBroadcasterUserLogin string `json:"from_broadcaster_user_login"`
+	FromBroadcasterUserName  string `json:"from_broadcaster_user_name"`
+	ToBroadcasterUserId      string `json:"to_broadcaster_user_id"`
+	ToBroadcasterUserLogin   string `json:"to_broadcaster_user_login"`
+	ToBroadcasterUserName    string `json:"to_broadcaster_user_name"`
+	Viewers                  int    `json:"viewers"`
+}
+
+type StreamOnlineEvent struct {
+	Broadcaster
+	Id        string    `json:"id"`
+	Type      string    `json:"type"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+type StreamOfflineEvent struct {
+	Broadcaster
+}
+
+type PollChoice struct {
+	Id                 string `json:"id"`
+	Title              string `json:"title"`
+	Votes              int    `json:"votes"`
+	ChannelPointsVotes int    `json:"channel_points_votes"`
+	BitsVotes          int    `json:"bits_votes"`
+}
+
+// PollEvent is sent for poll begin, progress, and end; EndedAt and Status
+// are only set once the poll ends.
+type PollEvent struct {
+	Broadcaster
+	Id        string       `json:"id"`
+	Title     string       `json:"title"`
+	Choices   []PollChoice `json:"choices"`
+	Status    string       `json:"status,omitempty"`
+	StartedAt time.Time    `json:"started_at"`
+	EndsAt    *time.Time   `json:"ends_at,omitempty"`
+	EndedAt   *time.Time   `json:"ended_at,omitempty"`
+}
+
+type PredictionOutcome struct {
+	Id            string `json:"id"`
+	Title         string `json:"title"`
+	Color         string `json:"color"`
+	Users         int    `json:"users"`
+	ChannelPoints int    `json:"channel_points"`
+}
+
+// PredictionEvent is sent for prediction begin, progress, lock, and end.
+type PredictionEvent struct {
+	Broadcaster
+	Id               string              `json:"id"`
+	Title            string              `json:"title"`
+	Outcomes         []PredictionOutcome `json:"outcomes"`
+	WinningOutcomeId string              `json:"winning_outcome_id,omitempty"`
+	Status           string              `json:"status,omitempty"`
+	StartedAt        time.Time           `json:"started_at"`
+	LocksAt          *time.Time          `json:"locks_at,omitempty"`
+	LockedAt         *time.Time          `json:"locked_at,omitempty"`
+	EndedAt          *time.Time          `json:"ended_at,omitempty"`
+}
+
+// HypeTrainEvent is sent for hype train begin, progress, and end, as of
+// version 2 of those subscriptions. Type is "regular", "treasure", or
+// "golden_kappa".
+type HypeTrainEvent struct {
+	Broadcaster
+	Id             string     `json:"id"`
+	Type           string     `json:"type"`
+	IsSharedTrain  bool       `json:"is_shared_train"`
+	Level          int        `json:"level"`
+	Total          int        `json:"total"`
+	Progress       int        `json:"progress"`
+	Goal           int        `json:"goal"`
+	StartedAt      time.Time  `json:"started_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	EndedAt        *time.Time `json:"ended_at,omitempty"`
+	CooldownEndsAt *time.Time `json:"cooldown_ends_at,omitempty"`
+}
+
+type AdBreakEvent struct {
+	Broadcaster
+	RequesterUserId    string    `json:"requester_user_id"`
+	RequesterUserLogin string    `json:"requester_user_login"`
+	RequesterUserName  string    `json:"requester_user_name"`
+	DurationSeconds    int       `json:"duration_seconds"`
+	IsAutomatic        bool      `json:"is_automatic"`
+	StartedAt          time.Time `json:"started_at"`
+}
+
+type Reward struct {
+	Id     string `json:"id"`
+	Title  string `json:"title"`
+	Cost   int    `json:"cost"`
+	Prompt string `json:"prompt"`
+}
+
+type ChannelPointsRedemptionEvent struct {
+	Broadcaster
+	Chatter
+	Id         string    `json:"id"`
+	UserInput  string    `json:"user_input"`
+	Status     string    `json:"status"`
+	Reward     Reward    `json:"reward"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+}
@@ -0,0 +1,91 @@
+package eventsub
+
+import "time"
+
+// Message types sent over the EventSub websocket.
+// https://dev.twitch.tv/docs/eventsub/websocket-reference/
+const (
+	MessageTypeWelcome      = "session_welcome"
+	MessageTypeKeepalive    = "session_keepalive"
+	MessageTypeReconnect    = "session_reconnect"
+	MessageTypeRevocation   = "revocation"
+	MessageTypeNotification = "notification"
+)
+
+type Metadata struct {
+	MessageId           string    `json:"message_id"`
+	MessageType         string    `json:"message_type"`
+	MessageTimestamp    time.Time `json:"message_timestamp"`
+	SubscriptionType    string    `json:"subscription_type,omitempty"`
+	SubscriptionVersion string    `json:"subscription_version,omitempty"`
+}
+
+// Meta lets every message type satisfy Message by embedding Metadata.
+func (m Metadata) Meta() Metadata {
+	return m
+}
+
+// Message is one of *WelcomeMessage, *KeepaliveMessage, *ReconnectMessage,
+// *RevocationMessage, or *NotificationMessage.
+type Message interface {
+	Meta() Metadata
+}
+
+type Session struct {
+	Id                      string    `json:"id"`
+	Status                  string    `json:"status"`
+	ConnectedAt             time.Time `json:"connected_at"`
+	KeepaliveTimeoutSeconds int64     `json:"keepalive_timeout_seconds"`
+	ReconnectUrl            *string   `json:"reconnect_url,omitempty"`
+}
+
+// KeepaliveTimeout is how long Twitch may stay silent before the session
+// should be considered dead.
+func (s Session) KeepaliveTimeout() time.Duration {
+	return time.Duration(s.KeepaliveTimeoutSeconds) * time.Second
+}
+
+type Transport struct {
+	Method    string `json:"method"`
+	SessionId string `json:"session_id,omitempty"`
+}
+
+type Subscription struct {
+	Id        string            `json:"id"`
+	Status    string            `json:"status"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Cost      int               `json:"cost"`
+	Condition map[string]string `json:"condition"`
+	Transport Transport         `json:"transport"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type WelcomeMessage struct {
+	Metadata
+	Session Session
+}
+
+type KeepaliveMessage struct {
+	Metadata
+}
+
+type ReconnectMessage struct {
+	Metadata
+	Session Session
+}
+
+type RevocationMessage struct {
+	Metadata
+	Subscription Subscription
+}
+
+// NotificationMessage carries an event for a subscription.
+// Event holds a pointer to the typed event struct for Metadata.SubscriptionType,
+// or nil when the type has no registered struct; RawEvent is always set.
+type NotificationMessage struct {
+	Metadata
+	Subscription Subscription
+	Event        interface{}
+	RawEvent     []byte
+}
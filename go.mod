@@ -1,18 +1,24 @@
 module github.com/kevinkjt2000/twitch-go-bot
 
-go 1.20
+go 1.21
 
 require (
 	github.com/caarlos0/env v3.5.0+incompatible
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/magefile/mage v1.15.0
 	github.com/spddl/go-twitch-ws v0.0.0-20210519195157-c49c94366ced
+	github.com/zalando/go-keyring v0.2.5
+	golang.org/x/crypto v0.15.0
 	golang.org/x/oauth2 v0.14.0
 	nhooyr.io/websocket v1.8.10
 )
 
 require (
+	github.com/alessio/shellescape v1.4.1 // indirect
+	github.com/danieljoos/wincred v1.2.0 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	golang.org/x/net v0.18.0 // indirect
+	golang.org/x/sys v0.14.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 )
@@ -0,0 +1,164 @@
+// Package mpris quiets media players over D-Bus while the bot talks, using
+// the MPRIS interface that Spotify, VLC, mpv, browsers, and most other
+// Linux players implement.
+package mpris
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	BusPrefix       = "org.mpris.MediaPlayer2."
+	ObjectPath      = "/org/mpris/MediaPlayer2"
+	PlayerInterface = "org.mpris.MediaPlayer2.Player"
+)
+
+// Ways of ducking a player
+const (
+	ModePause = "pause"
+	ModeLower = "lower"
+)
+
+// restoreTimeout bounds restoring players, which happens after the message
+// that ducked them may have been canceled.
+const restoreTimeout = 5 * time.Second
+
+// Ducker pauses or lowers the volume of every playing media player.
+type Ducker struct {
+	conn   *dbus.Conn
+	mode   string
+	volume float64
+}
+
+// Connect ducks players on the session bus. volume is the fraction of
+// their volume that players are lowered to in ModeLower.
+func Connect(mode string, volume float64) (*Ducker, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("mpris: %w", err)
+	}
+	ducker, err := New(conn, mode, volume)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ducker, nil
+}
+
+// New ducks players on conn, such as a private bus with a fake player.
+func New(conn *dbus.Conn, mode string, volume float64) (*Ducker, error) {
+	if mode != ModePause && mode != ModeLower {
+		return nil, fmt.Errorf("mpris: unknown mode %q", mode)
+	}
+	if volume < 0 || volume > 1 {
+		return nil, fmt.Errorf("mpris: volume %v is not between 0 and 1", volume)
+	}
+	return &Ducker{conn: conn, mode: mode, volume: volume}, nil
+}
+
+func (d *Ducker) Close() error {
+	return d.conn.Close()
+}
+
+// Duck quiets every playing player and returns a function that restores
+// them. When nothing is playing, restoring does nothing. Players that fail
+// to duck are left alone; the first such error is returned along with a
+// restore function for the rest.
+func (d *Ducker) Duck(ctx context.Context) (restore func(), err error) {
+	names, err := d.players(ctx)
+	if err != nil {
+		return func() {}, err
+	}
+	var restores []func(ctx context.Context)
+	for _, name := range names {
+		player := d.conn.Object(name, ObjectPath)
+		var status string
+		if err := getProperty(ctx, player, "PlaybackStatus", &status); err != nil || status != "Playing" {
+			continue
+		}
+		var undo func(ctx context.Context)
+		var duckErr error
+		if d.mode == ModePause {
+			undo, duckErr = pause(ctx, player)
+		} else {
+			undo, duckErr = lower(ctx, player, d.volume)
+		}
+		if duckErr != nil {
+			if err == nil {
+				err = fmt.Errorf("mpris: ducking %s: %w", strings.TrimPrefix(name, BusPrefix), duckErr)
+			}
+			continue
+		}
+		restores = append(restores, undo)
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+		defer cancel()
+		for _, undo := range restores {
+			undo(ctx)
+		}
+	}, err
+}
+
+// players lists the bus names of every MPRIS player.
+func (d *Ducker) players(ctx context.Context) ([]string, error) {
+	var names []string
+	err := d.conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.ListNames", 0).Store(&names)
+	if err != nil {
+		return nil, fmt.Errorf("mpris: listing players: %w", err)
+	}
+	var players []string
+	for _, name := range names {
+		if strings.HasPrefix(name, BusPrefix) {
+			players = append(players, name)
+		}
+	}
+	return players, nil
+}
+
+// pause pauses player, resuming it afterwards unless someone else has
+// changed it in the meantime.
+func pause(ctx context.Context, player dbus.BusObject) (func(ctx context.Context), error) {
+	if err := player.CallWithContext(ctx, PlayerInterface+".Pause", 0).Err; err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) {
+		var status string
+		if err := getProperty(ctx, player, "PlaybackStatus", &status); err != nil || status != "Paused" {
+			return
+		}
+		player.CallWithContext(ctx, PlayerInterface+".Play", 0)
+	}, nil
+}
+
+// lower scales player's volume by fraction, setting it back afterwards.
+func lower(ctx context.Context, player dbus.BusObject, fraction float64) (func(ctx context.Context), error) {
+	var volume float64
+	if err := getProperty(ctx, player, "Volume", &volume); err != nil {
+		return nil, err
+	}
+	if err := setProperty(ctx, player, "Volume", volume*fraction); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) {
+		setProperty(ctx, player, "Volume", volume)
+	}, nil
+}
+
+func getProperty(ctx context.Context, player dbus.BusObject, name string, value interface{}) error {
+	var variant dbus.Variant
+	err := player.CallWithContext(ctx, "org.freedesktop.DBus.Properties.Get", 0, PlayerInterface, name).Store(&variant)
+	if err != nil {
+		return err
+	}
+	return variant.Store(value)
+}
+
+func setProperty(ctx context.Context, player dbus.BusObject, name string, value interface{}) error {
+	return player.CallWithContext(ctx, "org.freedesktop.DBus.Properties.Set", 0, PlayerInterface, name, dbus.MakeVariant(value)).Err
+}
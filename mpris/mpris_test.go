@@ -0,0 +1,151 @@
+package mpris_test
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/kevinkjt2000/twitch-go-bot/mpris"
+	"github.com/kevinkjt2000/twitch-go-bot/mpris/mpristest"
+)
+
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*"/>
+    <allow receive_sender="*"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startBus runs a private dbus-daemon for the test and returns its address.
+func startBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(config, []byte(strings.ReplaceAll(busConfig, "%s", dir)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading the bus address: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+func connect(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func newPlayer(t *testing.T, address string, name string) *mpristest.Player {
+	t.Helper()
+	player, err := mpristest.NewPlayer(connect(t, address), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return player
+}
+
+func newDucker(t *testing.T, address string, mode string, volume float64) *mpris.Ducker {
+	t.Helper()
+	ducker, err := mpris.New(connect(t, address), mode, volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ducker
+}
+
+func TestDuckPause(t *testing.T) {
+	address := startBus(t)
+	playing := newPlayer(t, address, "playing")
+	paused := newPlayer(t, address, "paused")
+	paused.SetStatus(mpristest.Paused)
+	ducker := newDucker(t, address, mpris.ModePause, 0.3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	restore, err := ducker.Duck(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := playing.Status(); status != mpristest.Paused {
+		t.Errorf("playing player is %s while ducked, want %s", status, mpristest.Paused)
+	}
+	restore()
+	if status := playing.Status(); status != mpristest.Playing {
+		t.Errorf("playing player is %s after restoring, want %s", status, mpristest.Playing)
+	}
+	if status := paused.Status(); status != mpristest.Paused {
+		t.Errorf("paused player is %s after restoring, want it left %s", status, mpristest.Paused)
+	}
+}
+
+func TestDuckLower(t *testing.T) {
+	address := startBus(t)
+	player := newPlayer(t, address, "player")
+	player.SetVolume(0.8)
+	ducker := newDucker(t, address, mpris.ModeLower, 0.25)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	restore, err := ducker.Duck(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if volume := player.Volume(); volume != 0.2 {
+		t.Errorf("volume is %v while ducked, want 0.2", volume)
+	}
+	if status := player.Status(); status != mpristest.Playing {
+		t.Errorf("player is %s while ducked, want it still %s", status, mpristest.Playing)
+	}
+	restore()
+	if volume := player.Volume(); volume != 0.8 {
+		t.Errorf("volume is %v after restoring, want 0.8", volume)
+	}
+}
+
+func TestDuckWithoutPlayers(t *testing.T) {
+	address := startBus(t)
+	ducker := newDucker(t, address, mpris.ModePause, 0.3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	restore, err := ducker.Duck(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore()
+}
@@ -0,0 +1,111 @@
+// Package mpristest provides a fake MPRIS media player for testing ducking
+// without a real player.
+//
+// Run a private bus with dbus-daemon or dbus-run-session, connect to it with
+// dbus.Connect, and hand that connection to both NewPlayer and mpris.New.
+package mpristest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+	"github.com/kevinkjt2000/twitch-go-bot/mpris"
+)
+
+var ErrNameTaken = errors.New("mpristest: player name already taken")
+
+// Playback statuses
+const (
+	Playing = "Playing"
+	Paused  = "Paused"
+	Stopped = "Stopped"
+)
+
+// Player answers the parts of org.mpris.MediaPlayer2.Player that ducking
+// uses: Play, Pause, PlayPause, Stop, PlaybackStatus, and Volume.
+type Player struct {
+	conn  *dbus.Conn
+	name  string
+	props *prop.Properties
+}
+
+// NewPlayer claims org.mpris.MediaPlayer2.<name> on conn, starting out
+// playing at full volume. Each player needs a connection of its own.
+func NewPlayer(conn *dbus.Conn, name string) (*Player, error) {
+	p := &Player{conn: conn, name: mpris.BusPrefix + name}
+	props, err := prop.Export(conn, mpris.ObjectPath, prop.Map{
+		mpris.PlayerInterface: {
+			"PlaybackStatus": {Value: Playing, Emit: prop.EmitTrue},
+			"Volume":         {Value: 1.0, Writable: true, Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.props = props
+	if err := conn.Export(methods{p}, mpris.ObjectPath, mpris.PlayerInterface); err != nil {
+		return nil, err
+	}
+	reply, err := conn.RequestName(p.name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return nil, fmt.Errorf("%w: %s", ErrNameTaken, p.name)
+	}
+	return p, nil
+}
+
+// Close gives up the player's bus name.
+func (p *Player) Close() error {
+	_, err := p.conn.ReleaseName(p.name)
+	return err
+}
+
+func (p *Player) Status() string {
+	return p.props.GetMust(mpris.PlayerInterface, "PlaybackStatus").(string)
+}
+
+func (p *Player) SetStatus(status string) {
+	p.props.SetMust(mpris.PlayerInterface, "PlaybackStatus", status)
+}
+
+func (p *Player) Volume() float64 {
+	return p.props.GetMust(mpris.PlayerInterface, "Volume").(float64)
+}
+
+func (p *Player) SetVolume(volume float64) {
+	p.props.SetMust(mpris.PlayerInterface, "Volume", volume)
+}
+
+// methods are exported on the bus separately so that Player's own methods
+// are not callable over D-Bus.
+type methods struct {
+	p *Player
+}
+
+func (m methods) Play() *dbus.Error {
+	m.p.SetStatus(Playing)
+	return nil
+}
+
+func (m methods) Pause() *dbus.Error {
+	m.p.SetStatus(Paused)
+	return nil
+}
+
+func (m methods) PlayPause() *dbus.Error {
+	if m.p.Status() == Playing {
+		m.p.SetStatus(Paused)
+	} else {
+		m.p.SetStatus(Playing)
+	}
+	return nil
+}
+
+func (m methods) Stop() *dbus.Error {
+	m.p.SetStatus(Stopped)
+	return nil
+}
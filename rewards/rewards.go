@@ -0,0 +1,139 @@
+package rewards
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevinkjt2000/twitch-go-bot/eventsub"
+	"github.com/kevinkjt2000/twitch-go-bot/twitch"
+)
+
+// RewardHandler automates a channel points reward. Returning nil marks the
+// redemption FULFILLED; returning an error marks it CANCELED, refunding
+// the viewer's points, and a *RefundError also tells them why.
+type RewardHandler interface {
+	HandleRedemption(ctx context.Context, redemption *eventsub.ChannelPointsRedemptionEvent) error
+}
+
+// HandlerFunc adapts a function to a RewardHandler.
+type HandlerFunc func(ctx context.Context, redemption *eventsub.ChannelPointsRedemptionEvent) error
+
+func (f HandlerFunc) HandleRedemption(ctx context.Context, redemption *eventsub.ChannelPointsRedemptionEvent) error {
+	return f(ctx, redemption)
+}
+
+// RefundError explains to the viewer why their redemption was refunded.
+type RefundError struct {
+	// Reason finishes "Sorry @viewer, ..." in chat.
+	Reason string
+	Err    error
+}
+
+func (e *RefundError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RefundError) Unwrap() error {
+	return e.Err
+}
+
+// StatusUpdater marks redemptions as fulfilled or canceled.
+type StatusUpdater interface {
+	UpdateRedemptionStatus(ctx context.Context, broadcasterId string, rewardId string, redemptionId string, status string) error
+}
+
+// Chat sends chat messages to a channel, without the leading #.
+type Chat interface {
+	Say(channel string, message string)
+}
+
+// Dispatcher routes redemptions to the handler registered for the reward's
+// ID, falling back to one registered for its title.
+type Dispatcher struct {
+	updater StatusUpdater
+	chat    Chat
+
+	mu      sync.RWMutex
+	byId    map[string]RewardHandler
+	byTitle map[string]RewardHandler
+}
+
+// NewDispatcher updates redemptions with updater, and tells viewers in chat
+// when theirs was refunded.
+func NewDispatcher(updater StatusUpdater, chat Chat) *Dispatcher {
+	return &Dispatcher{
+		updater: updater,
+		chat:    chat,
+		byId:    map[string]RewardHandler{},
+		byTitle: map[string]RewardHandler{},
+	}
+}
+
+// HandleId registers handler for the reward with the given ID.
+func (d *Dispatcher) HandleId(rewardId string, handler RewardHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.byId[rewardId] = handler
+}
+
+// HandleTitle registers handler for rewards titled title, ignoring case.
+func (d *Dispatcher) HandleTitle(title string, handler RewardHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.byTitle[strings.ToLower(title)] = handler
+}
+
+func (d *Dispatcher) handler(reward eventsub.Reward) (RewardHandler, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if handler, ok := d.byId[reward.Id]; ok {
+		return handler, true
+	}
+	handler, ok := d.byTitle[strings.ToLower(reward.Title)]
+	return handler, ok
+}
+
+// statusTimeout bounds updating a redemption's status, which still happens
+// when ctx was canceled so that interrupted redemptions are refunded.
+const statusTimeout = 10 * time.Second
+
+// Dispatch runs the handler for a redemption and updates its status.
+// Rewards without a handler are left for the broadcaster to deal with.
+// Twitch only allows updating redemptions of rewards created by the bot's
+// client ID, and rewards that skip the request queue have no status to update.
+func (d *Dispatcher) Dispatch(ctx context.Context, redemption *eventsub.ChannelPointsRedemptionEvent) error {
+	handler, ok := d.handler(redemption.Reward)
+	if !ok {
+		return nil
+	}
+	handlerErr := handler.HandleRedemption(ctx, redemption)
+	if redemption.Status != twitch.RedemptionUnfulfilled {
+		return handlerErr
+	}
+
+	status := twitch.RedemptionFulfilled
+	if handlerErr != nil {
+		status = twitch.RedemptionCanceled
+	}
+	statusCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), statusTimeout)
+	defer cancel()
+	err := d.updater.UpdateRedemptionStatus(statusCtx, redemption.BroadcasterUserId, redemption.Reward.Id, redemption.Id, status)
+	if err != nil && handlerErr != nil {
+		return fmt.Errorf("%w (and failed to cancel redemption: %v)", handlerErr, err)
+	}
+	if handlerErr != nil {
+		reason := "your redemption could not be completed"
+		var refundErr *RefundError
+		if errors.As(handlerErr, &refundErr) {
+			reason = refundErr.Reason
+		}
+		d.chat.Say(redemption.BroadcasterUserLogin, fmt.Sprintf(
+			"Sorry @%s, %s so your channel points were refunded.", redemption.UserLogin, reason))
+		return handlerErr
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package tts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/caarlos0/env"
+)
+
+// Config is the "tts" section of the bot's config file, with environment
+// variables taking precedence over the file.
+type Config struct {
+	// Engine is one of festival, espeak-ng, piper, or pico2wave. When empty,
+	// the first installed engine is used.
+	Engine         string `env:"BOT_TTS_ENGINE" json:"engine"`
+	PiperVoicesDir string `env:"BOT_TTS_PIPER_VOICES_DIR" json:"piper_voices_dir"`
+
+	MaxLength  int `env:"BOT_TTS_MAX_LENGTH" json:"max_length"`
+	MaxSeconds int `env:"BOT_TTS_MAX_SECONDS" json:"max_seconds"`
+
+	// Sink is the PulseAudio or PipeWire sink to play on, such as a null sink
+	// that OBS captures on its own track. Empty plays on the default sink.
+	Sink string `env:"BOT_TTS_SINK" json:"sink"`
+	// GainDB is added to every message after normalizing its volume.
+	GainDB float64 `env:"BOT_TTS_GAIN_DB" json:"gain_db"`
+	// ClipsDir keeps a copy of every rendered message when set.
+	ClipsDir string `env:"BOT_TTS_CLIPS_DIR" json:"clips_dir"`
+
+	// Duck is "pause" to pause media players while a message plays, or
+	// "lower" to turn them down to DuckVolume, from 0 (muted) to 1.
+	// Empty leaves them alone.
+	Duck       string  `env:"BOT_TTS_DUCK" json:"duck"`
+	DuckVolume float64 `env:"BOT_TTS_DUCK_VOLUME" json:"duck_volume"`
+
+	// BannedWords are removed from messages before they are spoken.
+	BannedWords []string `env:"BOT_TTS_BANNED_WORDS" envSeparator:"," json:"banned_words"`
+}
+
+// LoadConfig reads the "tts" section of the config file at path, if there
+// is one, then applies environment variables and defaults.
+func LoadConfig(path string) (Config, error) {
+	var file struct {
+		TTS Config `json:"tts"`
+	}
+	// 0 mutes players, so DuckVolume defaults before reading settings
+	file.TTS.DuckVolume = 0.3
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &file); err != nil {
+			return file.TTS, fmt.Errorf("tts: parsing %s: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return file.TTS, err
+	}
+	conf := file.TTS
+	if err := env.Parse(&conf); err != nil {
+		return conf, err
+	}
+	if conf.PiperVoicesDir == "" {
+		conf.PiperVoicesDir = "/usr/share/piper-voices"
+	}
+	if conf.MaxLength == 0 {
+		conf.MaxLength = 300
+	}
+	if conf.MaxSeconds == 0 {
+		conf.MaxSeconds = 30
+	}
+	return conf, nil
+}
+
+// MaxDuration is how long a message may play before it is cut off.
+func (c Config) MaxDuration() time.Duration {
+	return time.Duration(c.MaxSeconds) * time.Second
+}
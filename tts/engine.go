@@ -0,0 +1,82 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNoEngine     = errors.New("tts: no TTS engine is installed")
+	ErrVoiceMissing = errors.New("tts: voice is missing")
+	ErrTimeout      = errors.New("tts: timed out")
+	ErrEngineFailed = errors.New("tts: engine failed")
+)
+
+// Engine is a text to speech program.
+type Engine interface {
+	Name() string
+	// Available reports whether the engine is installed.
+	Available() bool
+	// Voices lists the voices that may be passed to Render.
+	Voices() ([]string, error)
+	// Render writes text said with voice to the WAV file at wavPath.
+	Render(ctx context.Context, voice string, text string, wavPath string) error
+}
+
+// engines lists every engine in the order they are tried when none is configured.
+func engines(conf Config) []Engine {
+	return []Engine{
+		festival{},
+		espeakNG{},
+		piper{voicesDir: conf.PiperVoicesDir},
+		pico2wave{},
+	}
+}
+
+// NewEngine returns the configured engine, or the first installed engine
+// that has voices when none is configured. It returns ErrNoEngine when
+// nothing usable is installed.
+func NewEngine(conf Config) (Engine, error) {
+	for _, engine := range engines(conf) {
+		if conf.Engine != "" && conf.Engine != engine.Name() {
+			continue
+		}
+		if !engine.Available() {
+			continue
+		}
+		if voices, err := engine.Voices(); err != nil || len(voices) == 0 {
+			continue
+		}
+		return engine, nil
+	}
+	if conf.Engine != "" {
+		return nil, fmt.Errorf("%w (%s)", ErrNoEngine, conf.Engine)
+	}
+	return nil, ErrNoEngine
+}
+
+func installed(program string) bool {
+	_, err := exec.LookPath(program)
+	return err == nil
+}
+
+// run waits for cmd, turning a timeout or failure into ErrTimeout or
+// ErrEngineFailed with the program's error output. The error output is also
+// returned for programs that report some failures without a non-zero exit.
+func run(ctx context.Context, cmd *exec.Cmd) (string, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return stderr.String(), ErrTimeout
+	}
+	if err != nil {
+		return stderr.String(), fmt.Errorf("%w: %s: %v: %s", ErrEngineFailed, filepath.Base(cmd.Path), err, strings.TrimSpace(stderr.String()))
+	}
+	return stderr.String(), nil
+}
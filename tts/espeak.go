@@ -0,0 +1,43 @@
+package tts
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+)
+
+type espeakNG struct{}
+
+func (espeakNG) Name() string {
+	return "espeak-ng"
+}
+
+func (espeakNG) Available() bool {
+	return installed("espeak-ng")
+}
+
+// Voices parses the language column of `espeak-ng --voices`, which looks like:
+//
+//	Pty Language       Age/Gender VoiceName          File                 Other Languages
+//	 5  en-us           --/M      English_(America)  gmw/en-US            (en 3)
+func (espeakNG) Voices() ([]string, error) {
+	output, err := exec.Command("espeak-ng", "--voices").Output()
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	var voices []string
+	for _, line := range lines[1:] {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			voices = append(voices, fields[1])
+		}
+	}
+	return voices, nil
+}
+
+func (espeakNG) Render(ctx context.Context, voice string, text string, wavPath string) error {
+	cmd := exec.CommandContext(ctx, "espeak-ng", "-v", voice, "-w", wavPath, "--stdin")
+	cmd.Stdin = strings.NewReader(text)
+	_, err := run(ctx, cmd)
+	return err
+}
@@ -0,0 +1,76 @@
+package tts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const festivalVoicesDir = "/usr/share/festival/voices"
+
+// voicePattern matches the festival voice names that are safe to put in Scheme.
+var voicePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+type festival struct{}
+
+func (festival) Name() string {
+	return "festival"
+}
+
+func (festival) Available() bool {
+	return installed("text2wave")
+}
+
+// Voices lists the voice directories of every installed language.
+func (festival) Voices() ([]string, error) {
+	dirs, err := filepath.Glob(filepath.Join(festivalVoicesDir, "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	var voices []string
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			voices = append(voices, filepath.Base(dir))
+		}
+	}
+	return voices, nil
+}
+
+// Render never puts text into festival's Scheme interpreter. The text is
+// written to a file which text2wave reads as plain text, and only the voice
+// name, checked against the installed voices, is Scheme.
+func (f festival) Render(ctx context.Context, voice string, text string, wavPath string) error {
+	if !voicePattern.MatchString(voice) {
+		return fmt.Errorf("%w: %q", ErrVoiceMissing, voice)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(festivalVoicesDir, "*", voice)); len(matches) == 0 {
+		return fmt.Errorf("%w: %s", ErrVoiceMissing, voice)
+	}
+	textFile, err := os.CreateTemp("", "tts-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(textFile.Name())
+	_, err = textFile.WriteString(text)
+	if closeErr := textFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "text2wave", "-eval", fmt.Sprintf(`(voice_%s)`, voice), "-o", wavPath, textFile.Name())
+	stderr, err := run(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	// festival reports Scheme errors such as an unknown voice without failing
+	if strings.Contains(stderr, "SIOD ERROR") {
+		return fmt.Errorf("%w: festival: %s", ErrEngineFailed, strings.TrimSpace(stderr))
+	}
+	return nil
+}
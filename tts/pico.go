@@ -0,0 +1,42 @@
+package tts
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// picoVoices are the languages built into SVOX Pico.
+var picoVoices = []string{"en-US", "en-GB", "de-DE", "es-ES", "fr-FR", "it-IT"}
+
+type pico2wave struct{}
+
+func (pico2wave) Name() string {
+	return "pico2wave"
+}
+
+func (pico2wave) Available() bool {
+	return installed("pico2wave")
+}
+
+func (pico2wave) Voices() ([]string, error) {
+	return picoVoices, nil
+}
+
+func (pico2wave) Render(ctx context.Context, voice string, text string, wavPath string) error {
+	if !contains(picoVoices, voice) {
+		return fmt.Errorf("%w: %s", ErrVoiceMissing, voice)
+	}
+	cmd := exec.CommandContext(ctx, "pico2wave", "-l", voice, "-w", wavPath, "--", text)
+	_, err := run(ctx, cmd)
+	return err
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package tts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// piper speaks with the .onnx voice models in voicesDir, each of which needs
+// its .onnx.json config file alongside it.
+type piper struct {
+	voicesDir string
+}
+
+func (piper) Name() string {
+	return "piper"
+}
+
+func (p piper) Available() bool {
+	return installed("piper")
+}
+
+func (p piper) Voices() ([]string, error) {
+	models, err := filepath.Glob(filepath.Join(p.voicesDir, "*.onnx"))
+	if err != nil {
+		return nil, err
+	}
+	voices := make([]string, len(models))
+	for i, model := range models {
+		voices[i] = strings.TrimSuffix(filepath.Base(model), ".onnx")
+	}
+	return voices, nil
+}
+
+func (p piper) Render(ctx context.Context, voice string, text string, wavPath string) error {
+	model := filepath.Join(p.voicesDir, voice+".onnx")
+	if _, err := os.Stat(model); err != nil {
+		return fmt.Errorf("%w: %s", ErrVoiceMissing, voice)
+	}
+	cmd := exec.CommandContext(ctx, "piper", "--model", model, "--output_file", wavPath)
+	cmd.Stdin = strings.NewReader(text)
+	_, err := run(ctx, cmd)
+	return err
+}
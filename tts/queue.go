@@ -0,0 +1,185 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTooLong = errors.New("tts: message is too long")
+	ErrSkipped = errors.New("tts: message was skipped")
+)
+
+// SpeakFunc says text with voice, returning once it has finished or ctx is done.
+type SpeakFunc func(ctx context.Context, voice string, text string) error
+
+// Queue plays messages one at a time so they never talk over each other,
+// even when they come from different channels. Moderators only manage
+// their own channel's messages, so Len, Skip, and Clear take a channel.
+type Queue struct {
+	speak       SpeakFunc
+	maxLength   int
+	maxDuration time.Duration
+
+	mu      sync.Mutex
+	pending []*request
+	playing *request
+	wake    chan struct{}
+}
+
+type request struct {
+	channel string
+	voice   string
+	text    string
+	done    chan error
+	cancel  context.CancelFunc
+	skipped bool
+	// stop stops taking the message out of the queue when its ctx is done
+	stop func() bool
+}
+
+// NewQueue creates a queue rejecting messages over maxLength characters and
+// cutting off playback after maxDuration.
+func NewQueue(speak SpeakFunc, maxLength int, maxDuration time.Duration) *Queue {
+	return &Queue{
+		speak:       speak,
+		maxLength:   maxLength,
+		maxDuration: maxDuration,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// MaxLength is the longest message in characters that Enqueue accepts.
+func (q *Queue) MaxLength() int {
+	return q.maxLength
+}
+
+// Enqueue adds text from channel, to be said with voice, to the end of the
+// queue. It returns the message's place in line, counting a message that is
+// playing and those from other channels, and a channel that receives the
+// playback result: nil, ErrSkipped, the error from speaking, or ctx's error
+// if ctx is done before the message starts playing, which takes it out of
+// the queue. Once playing, a message plays to the end regardless of ctx.
+func (q *Queue) Enqueue(ctx context.Context, channel string, voice string, text string) (position int, done <-chan error, err error) {
+	if len([]rune(text)) > q.maxLength {
+		return 0, nil, fmt.Errorf("%w (over %d characters)", ErrTooLong, q.maxLength)
+	}
+	req := &request{channel: channel, voice: voice, text: text, done: make(chan error, 1)}
+	q.mu.Lock()
+	q.pending = append(q.pending, req)
+	position = len(q.pending)
+	if q.playing != nil {
+		position++
+	}
+	// registered with the lock held, so that it finds req queued even if
+	// ctx is already done
+	req.stop = context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if i := slices.Index(q.pending, req); i >= 0 {
+			q.pending = slices.Delete(q.pending, i, i+1)
+			req.done <- ctx.Err()
+		}
+	})
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return position, req.done, nil
+}
+
+// Len counts channel's queued messages, including one that is playing.
+func (q *Queue) Len(channel string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, req := range q.pending {
+		if req.channel == channel {
+			n++
+		}
+	}
+	if q.playing != nil && q.playing.channel == channel {
+		n++
+	}
+	return n
+}
+
+// Skip stops the message that is playing if it is from channel, reporting
+// whether it was.
+func (q *Queue) Skip(channel string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.playing == nil || q.playing.channel != channel {
+		return false
+	}
+	q.playing.skip()
+	return true
+}
+
+// Clear stops the message that is playing and drops every queued message
+// from channel, returning how many were removed.
+func (q *Queue) Clear(channel string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	if q.playing != nil && q.playing.channel == channel {
+		q.playing.skip()
+		n++
+	}
+	kept := q.pending[:0]
+	for _, req := range q.pending {
+		if req.channel == channel {
+			req.stop()
+			req.done <- ErrSkipped
+			n++
+		} else {
+			kept = append(kept, req)
+		}
+	}
+	q.pending = kept
+	return n
+}
+
+func (r *request) skip() {
+	r.skipped = true
+	r.cancel()
+}
+
+// Run plays queued messages until ctx is done.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+				continue
+			}
+		}
+		req := q.pending[0]
+		q.pending = q.pending[1:]
+		req.stop()
+		playCtx, cancel := context.WithTimeout(ctx, q.maxDuration)
+		req.cancel = cancel
+		q.playing = req
+		q.mu.Unlock()
+
+		err := q.speak(playCtx, req.voice, req.text)
+		cancel()
+
+		q.mu.Lock()
+		q.playing = nil
+		if req.skipped {
+			err = ErrSkipped
+		}
+		q.mu.Unlock()
+		req.done <- err
+	}
+}
@@ -0,0 +1,46 @@
+package tts
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+|\b[\w-]+(?:\.[\w-]+)*\.(?:com|net|org|tv|gg|io|ly|me|co|be|xyz)\b\S*`)
+
+// Sanitizer cleans up viewer text before it is spoken.
+type Sanitizer struct {
+	bannedWords *regexp.Regexp
+}
+
+// NewSanitizer creates a sanitizer that also removes bannedWords, matched
+// as whole words ignoring case.
+func NewSanitizer(bannedWords []string) *Sanitizer {
+	var quoted []string
+	for _, word := range bannedWords {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	s := &Sanitizer{}
+	if len(quoted) > 0 {
+		s.bannedWords = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return s
+}
+
+// Sanitize strips control characters, URLs, and banned words, and collapses
+// the whitespace left behind.
+func (s *Sanitizer) Sanitize(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text)
+	text = urlPattern.ReplaceAllString(text, " ")
+	if s.bannedWords != nil {
+		text = s.bannedWords.ReplaceAllString(text, " ")
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
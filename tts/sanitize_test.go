@@ -0,0 +1,96 @@
+package tts_test
+
+import (
+	"testing"
+
+	"github.com/kevinkjt2000/twitch-go-bot/tts"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name        string
+		bannedWords []string
+		text        string
+		want        string
+	}{
+		{
+			name: "plain text",
+			text: "hello chat, how is everyone?",
+			want: "hello chat, how is everyone?",
+		},
+		{
+			name: "http URL",
+			text: "check out https://example.com/watch?v=1 now",
+			want: "check out now",
+		},
+		{
+			name: "www URL",
+			text: "go to www.example.org please",
+			want: "go to please",
+		},
+		{
+			name: "bare domain",
+			text: "join discord.gg/abc and twitch.tv",
+			want: "join and",
+		},
+		{
+			name: "domain in capitals",
+			text: "VISIT EXAMPLE.COM",
+			want: "VISIT",
+		},
+		{
+			name: "sentence ending without a space",
+			text: "that was great.thanks",
+			want: "that was great.thanks",
+		},
+		{
+			name:        "banned word",
+			bannedWords: []string{"heck"},
+			text:        "what the heck is this",
+			want:        "what the is this",
+		},
+		{
+			name:        "banned word ignoring case",
+			bannedWords: []string{"heck"},
+			text:        "HECK yes, Heck",
+			want:        "yes,",
+		},
+		{
+			name:        "banned word inside another word",
+			bannedWords: []string{"heck"},
+			text:        "checking the checklist",
+			want:        "checking the checklist",
+		},
+		{
+			name:        "banned words with regexp characters and spaces",
+			bannedWords: []string{"a.b", " oh no "},
+			text:        "a.b axb oh no code",
+			want:        "axb code",
+		},
+		{
+			name:        "blank banned words ignored",
+			bannedWords: []string{"", "  "},
+			text:        "nothing banned",
+			want:        "nothing banned",
+		},
+		{
+			name: "control characters and whitespace",
+			text: "line one\nline\ttwo\x07  three",
+			want: "line one line two three",
+		},
+		{
+			name:        "nothing left",
+			bannedWords: []string{"heck"},
+			text:        "heck https://example.com",
+			want:        "",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if got := tts.NewSanitizer(test.bannedWords).Sanitize(test.text); got != test.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+var ErrNoPlayer = errors.New("tts: paplay is not installed")
+
+// Ducker quiets other audio, such as music, while a message plays.
+type Ducker interface {
+	// Duck returns a function that restores the audio it quieted, even
+	// when it also returns an error.
+	Duck(ctx context.Context) (restore func(), err error)
+}
+
+// Speaker renders messages to WAV files with an engine, normalizes their
+// volume, optionally keeps a copy, and plays them on the configured sink.
+type Speaker struct {
+	engine   Engine
+	sink     string
+	gainDB   float64
+	clipsDir string
+	ducker   Ducker
+	logger   *slog.Logger
+}
+
+// NewSpeaker plays messages with engine, ducking other audio with ducker
+// unless it is nil.
+func NewSpeaker(engine Engine, conf Config, ducker Ducker, logger *slog.Logger) *Speaker {
+	return &Speaker{
+		engine:   engine,
+		sink:     conf.Sink,
+		gainDB:   conf.GainDB,
+		clipsDir: conf.ClipsDir,
+		ducker:   ducker,
+		logger:   logger,
+	}
+}
+
+// Speak renders and plays text, satisfying SpeakFunc. It fails with
+// ErrTimeout only if ctx's deadline passes while rendering; a message cut
+// off while playing has been heard, so that counts as played.
+func (s *Speaker) Speak(ctx context.Context, voice string, text string) error {
+	wav, err := os.CreateTemp("", "tts-*.wav")
+	if err != nil {
+		return err
+	}
+	wav.Close()
+	defer os.Remove(wav.Name())
+
+	if err := s.engine.Render(ctx, voice, text, wav.Name()); err != nil {
+		return err
+	}
+	if err := normalizeWav(wav.Name(), s.gainDB); err != nil {
+		s.logger.Warn("Playing TTS without normalizing", "engine", s.engine.Name(), "err", err)
+	}
+	if s.clipsDir != "" {
+		if err := s.saveClip(wav.Name(), voice, text); err != nil {
+			s.logger.Error("Failed to save TTS clip", "err", err)
+		}
+	}
+	if s.ducker != nil {
+		restore, err := s.ducker.Duck(ctx)
+		if err != nil {
+			s.logger.Warn("Failed to duck audio", "err", err)
+		}
+		defer restore()
+	}
+	return s.play(ctx, wav.Name())
+}
+
+// play uses paplay, which PipeWire also provides, so the audio can go to a
+// sink of its own for OBS to capture. aplay is a fallback when no sink is set.
+func (s *Speaker) play(ctx context.Context, wavPath string) error {
+	var cmd *exec.Cmd
+	switch {
+	case installed("paplay") && s.sink != "":
+		cmd = exec.CommandContext(ctx, "paplay", "--device="+s.sink, wavPath)
+	case installed("paplay"):
+		cmd = exec.CommandContext(ctx, "paplay", wavPath)
+	case installed("aplay") && s.sink == "":
+		cmd = exec.CommandContext(ctx, "aplay", "-q", wavPath)
+	default:
+		return ErrNoPlayer
+	}
+	_, err := run(ctx, cmd)
+	if errors.Is(err, ErrTimeout) {
+		s.logger.Info("Cut off TTS message at the time limit")
+		return nil
+	}
+	return err
+}
+
+// saveClip copies a rendered message into the clips directory, with the
+// text alongside it for review.
+func (s *Speaker) saveClip(wavPath string, voice string, text string) error {
+	if err := os.MkdirAll(s.clipsDir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(s.clipsDir, fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405.000"), voice))
+	if err := os.WriteFile(name+".txt", []byte(text+"\n"), 0o644); err != nil {
+		return err
+	}
+	src, err := os.Open(wavPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(name + ".wav")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
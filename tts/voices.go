@@ -0,0 +1,69 @@
+package tts
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+var ErrUnknownVoice = errors.New("tts: unknown voice")
+
+// Voices picks the voice for each message from an engine's voices.
+type Voices struct {
+	voices []string
+}
+
+func NewVoices(voices []string) Voices {
+	return Voices{voices: voices}
+}
+
+// List returns every voice name.
+func (v Voices) List() []string {
+	return v.voices
+}
+
+// Find returns the voice named name, ignoring case.
+func (v Voices) Find(name string) (string, bool) {
+	for _, voice := range v.voices {
+		if strings.EqualFold(voice, name) {
+			return voice, true
+		}
+	}
+	return "", false
+}
+
+// Random returns any voice.
+func (v Voices) Random() string {
+	return v.voices[rand.Intn(len(v.voices))]
+}
+
+// Choose splits a leading voice tag like "[kal] hello" from text. Without a
+// tag it uses defaultVoice, or a random voice when that is empty. A tag
+// naming a voice that does not exist is an ErrUnknownVoice.
+func (v Voices) Choose(text string, defaultVoice string) (voice string, rest string, err error) {
+	tag, rest, ok := splitVoiceTag(text)
+	if !ok {
+		if defaultVoice == "" {
+			return v.Random(), text, nil
+		}
+		return defaultVoice, text, nil
+	}
+	voice, ok = v.Find(tag)
+	if !ok {
+		return "", text, fmt.Errorf("%w: %s", ErrUnknownVoice, tag)
+	}
+	return voice, rest, nil
+}
+
+func splitVoiceTag(text string) (tag string, rest string, ok bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "[") {
+		return "", text, false
+	}
+	tag, rest, ok = strings.Cut(text[1:], "]")
+	if !ok {
+		return "", text, false
+	}
+	return strings.TrimSpace(tag), strings.TrimSpace(rest), true
+}
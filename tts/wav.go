@@ -0,0 +1,78 @@
+package tts
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+)
+
+// targetRMS is the loudness every message is normalized to, about -20 dBFS.
+const targetRMS = 0.1
+
+var errUnsupportedWav = errors.New("tts: only 16-bit PCM WAV files can be normalized")
+
+// normalizeWav rescales a 16-bit PCM WAV file in place so every message is
+// about as loud, then applies gainDB, without letting samples clip.
+func normalizeWav(path string, gainDB float64) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	samples, err := wavSamples(data)
+	if err != nil {
+		return err
+	}
+
+	var sumSquares float64
+	var peak float64
+	for i := 0; i+1 < len(samples); i += 2 {
+		sample := float64(int16(binary.LittleEndian.Uint16(samples[i:]))) / math.MaxInt16
+		sumSquares += sample * sample
+		peak = math.Max(peak, math.Abs(sample))
+	}
+	if peak == 0 {
+		return nil // silence
+	}
+	rms := math.Sqrt(sumSquares / float64(len(samples)/2))
+	scale := targetRMS / rms * math.Pow(10, gainDB/20)
+	scale = math.Min(scale, 0.99/peak)
+	for i := 0; i+1 < len(samples); i += 2 {
+		sample := float64(int16(binary.LittleEndian.Uint16(samples[i:])))
+		binary.LittleEndian.PutUint16(samples[i:], uint16(int16(math.Round(sample*scale))))
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// wavSamples returns the slice of data holding a 16-bit PCM WAV's samples.
+func wavSamples(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, errUnsupportedWav
+	}
+	pcm16 := false
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		body := data[offset+8:]
+		if size > len(body) {
+			// Engines writing to a pipe may leave the size unset
+			size = len(body)
+		}
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, errUnsupportedWav
+			}
+			format := binary.LittleEndian.Uint16(body[0:])
+			bitsPerSample := binary.LittleEndian.Uint16(body[14:])
+			pcm16 = format == 1 && bitsPerSample == 16
+		case "data":
+			if !pcm16 {
+				return nil, errUnsupportedWav
+			}
+			return body[:size], nil
+		}
+		offset += 8 + size + size%2 // chunks are padded to even sizes
+	}
+	return nil, errUnsupportedWav
+}
@@ -3,12 +3,15 @@ package twitch
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,83 +19,455 @@ import (
 	"golang.org/x/oauth2"
 )
 
-func fetchTokenFromServer(ctx context.Context, conf Config) (*oauth2.Token, error) {
-	oauthConf := createOauthClient(conf)
-	code, err := authenticate(oauthConf)
-	if err != nil {
+// Grant is a token the bot needs: who has to authorize it and for what.
+type Grant struct {
+	// Name keys the token in the token file.
+	Name string
+	// Login is the account that should authorize the token.
+	Login  string
+	Scopes []string
+}
+
+// BotGrant is the token the bot chats with.
+func BotGrant(conf Config) Grant {
+	return Grant{
+		Name:  "bot",
+		Login: conf.BotLogin,
+		// https://dev.twitch.tv/docs/authentication/scopes/
+		Scopes: []string{
+			"chat:edit",
+			"chat:read",
+			"channel:moderate",
+			"whispers:read",
+			"whispers:edit",
+		},
+	}
+}
+
+// BroadcasterGrant is the token for channel's EventSub subscriptions and
+// channel point rewards, which Twitch only allows the broadcaster to grant.
+func BroadcasterGrant(channel string) Grant {
+	return Grant{
+		Name:  "broadcaster:" + channel,
+		Login: channel,
+		Scopes: []string{
+			"channel:manage:redemptions",
+			"moderator:read:followers",
+			"channel:read:subscriptions",
+			"bits:read",
+			"channel:read:polls",
+			"channel:read:predictions",
+			"channel:read:hype_train",
+			"channel:read:ads",
+		},
+	}
+}
+
+// fetchTokenFromServer asks someone to authorize grant and saves the token.
+// It refuses up front for a read-only store, which would lose the token.
+func fetchTokenFromServer(ctx context.Context, conf Config, store TokenStore, grant Grant, logger *slog.Logger) (*oauth2.Token, error) {
+	if readOnly(store) {
+		return nil, fmt.Errorf("%w: %s token cannot be refreshed, replace it in TWITCH_TOKENS", ErrReadOnlyStore, grant.Name)
+	}
+	oauthConf := createOauthClient(conf, grant.Scopes)
+	logger = logger.With("account", grant.Login)
+	var token *oauth2.Token
+	var err error
+	if conf.AuthFlow == AuthFlowDevice {
+		token, err = authenticateDevice(ctx, oauthConf, logger)
+	} else {
+		var code string
+		code, err = authenticate(ctx, oauthConf, logger)
+		if err != nil {
+			return nil, err
+		}
+		token, err = oauthConf.Exchange(ctx, code)
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return nil, fmt.Errorf("%w: %w", ErrAuth, err)
+	} else if err != nil {
 		return nil, err
 	}
-	token, err := oauthConf.Exchange(ctx, code)
-	if err != nil {
+	if err := store.Save(grant.Name, token); err != nil {
 		return nil, err
 	}
-	tokenData, err := json.Marshal(token)
+	return token, nil
+}
+
+// AcquireToken returns the token for grant from store, refreshing it if it
+// expired, or asks someone to authorize it when there is none.
+func AcquireToken(ctx context.Context, conf Config, store TokenStore, grant Grant, logger *slog.Logger) (*oauth2.Token, error) {
+	logger = logger.With("token", grant.Name)
+	token, err := store.Load(grant.Name)
 	if err != nil {
 		return nil, err
 	}
-	os.WriteFile(".twitch_token", tokenData, 0o600)
+	if token == nil {
+		// Token was missing, so we contact auth servers
+		return fetchTokenFromServer(ctx, conf, store, grant, logger)
+	}
+	if token.Expiry.Before(time.Now()) {
+		if token.RefreshToken != "" {
+			oauthConf := createOauthClient(conf, grant.Scopes)
+			refreshed, err := oauthConf.TokenSource(ctx, token).Token()
+			if err == nil {
+				if err := store.Save(grant.Name, refreshed); err != nil && !errors.Is(err, ErrReadOnlyStore) {
+					return nil, err
+				}
+				return refreshed, nil
+			}
+			logger.Warn("Failed to refresh token", "err", err)
+		}
+		logger.Info("Token is expired, fetching a new one")
+		return fetchTokenFromServer(ctx, conf, store, grant, logger)
+	}
 	return token, nil
 }
 
-func AcquireToken(ctx context.Context, conf Config) (*oauth2.Token, error) {
-	data, fileErr := os.ReadFile(".twitch_token")
-	if fileErr != nil {
-		// Token file was missing, so we contact auth servers
-		return fetchTokenFromServer(ctx, conf)
-	}
+// RefreshingTokenSource persists every newly refreshed token and reports it
+// to onRefresh so long-lived connections can pick it up.
+type RefreshingTokenSource struct {
+	// ctx outlives any one request, for refreshing and renewing the token
+	ctx       context.Context
+	mu        sync.Mutex
+	conf      Config
+	store     TokenStore
+	grant     Grant
+	source    oauth2.TokenSource
+	current   *oauth2.Token
+	renewal   *renewal
+	onRefresh func(*oauth2.Token)
+	logger    *slog.Logger
+}
 
-	var token oauth2.Token
-	if err := json.Unmarshal(data, &token); err != nil {
+// renewal is a Renew in progress, which concurrent calls wait for.
+type renewal struct {
+	done chan struct{}
+	err  error
+}
+
+func (r *RefreshingTokenSource) Token() (*oauth2.Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, err := r.source.Token()
+	if err != nil {
 		return nil, err
 	}
-	if token.Expiry.Before(time.Now()) {
-		fmt.Println("Token is expired need to fetch a new one")
-		return fetchTokenFromServer(ctx, conf)
+	if token.AccessToken != r.current.AccessToken {
+		r.replace(token)
 	}
-	return &token, nil
+	// callers such as oauth2.Transport write to the tokens they are given
+	copied := *token
+	return &copied, nil
 }
 
-func NewAuthClient(ctx context.Context, conf Config, token *oauth2.Token) (*http.Client, error) {
-	oauthConf := createOauthClient(conf)
-	return oauthConf.Client(ctx, token), nil
+// replace switches to token, which must be saved unless it came from the
+// store.
+func (r *RefreshingTokenSource) replace(token *oauth2.Token) {
+	r.current = token
+	if err := r.store.Save(r.grant.Name, token); errors.Is(err, ErrReadOnlyStore) {
+		r.logger.Debug("Refreshed token is only kept in memory")
+	} else if err != nil {
+		r.logger.Error("Failed to save refreshed token", "err", err)
+	}
+	if r.onRefresh != nil {
+		r.onRefresh(token)
+	}
+}
+
+// NewTokenSource returns a token source that refreshes grant's token using
+// its refresh token, saving the result to store and calling onRefresh
+// whenever it changes. logger should say which token it is.
+func NewTokenSource(ctx context.Context, conf Config, store TokenStore, grant Grant, token *oauth2.Token, onRefresh func(*oauth2.Token), logger *slog.Logger) *RefreshingTokenSource {
+	oauthConf := createOauthClient(conf, grant.Scopes)
+	return &RefreshingTokenSource{
+		ctx:       ctx,
+		conf:      conf,
+		store:     store,
+		grant:     grant,
+		source:    oauthConf.TokenSource(ctx, token),
+		current:   token,
+		onRefresh: onRefresh,
+		logger:    logger,
+	}
+}
+
+// validateInterval is how often Twitch requires apps to validate tokens.
+// https://dev.twitch.tv/docs/authentication/validate-tokens/
+const validateInterval = time.Hour
+
+var errInvalidToken = errors.New("twitch: invalid access token")
+
+// tokenInfo is what /oauth2/validate says about a token.
+type tokenInfo struct {
+	ClientId  string   `json:"client_id"`
+	Login     string   `json:"login"`
+	UserId    string   `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int      `json:"expires_in"`
+}
+
+func validateToken(ctx context.Context, authURL string, accessToken string) (tokenInfo, error) {
+	var info tokenInfo
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authURL+"/validate", nil)
+	if err != nil {
+		return info, err
+	}
+	req.Header.Set("Authorization", "OAuth "+accessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return info, errInvalidToken
+	case resp.StatusCode != http.StatusOK:
+		return info, fmt.Errorf("twitch: validating token: %s", resp.Status)
+	}
+	return info, json.NewDecoder(resp.Body).Decode(&info)
+}
+
+// Validate checks the token with Twitch, which is the only way to notice it
+// was revoked. An invalid token is renewed. A token belonging to another
+// account fails with ErrAuth. Missing scopes are only logged, as the bot can
+// still do the rest.
+func (r *RefreshingTokenSource) Validate(ctx context.Context) error {
+	info, err := validateToken(ctx, r.conf.AuthURL, r.accessToken())
+	if errors.Is(err, errInvalidToken) {
+		r.logger.Warn("Token is no longer valid, refreshing it")
+		if err := r.Renew(ctx); err != nil {
+			return err
+		}
+		info, err = validateToken(ctx, r.conf.AuthURL, r.accessToken())
+		if errors.Is(err, errInvalidToken) {
+			return fmt.Errorf("%w: renewed %s token is not valid either", ErrAuth, r.grant.Name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(info.Login, r.grant.Login) {
+		return fmt.Errorf("%w: %s token belongs to %q instead of %s, remove it from the token store to authorize it again", ErrAuth, r.grant.Name, info.Login, r.grant.Login)
+	}
+	if missing := missingScopes(r.grant.Scopes, info.Scopes); len(missing) > 0 {
+		r.logger.Error("Token is missing scopes, remove it from the token store to authorize it again", "account", info.Login, "missing", missing)
+	}
+	return nil
+}
+
+func (r *RefreshingTokenSource) accessToken() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current.AccessToken
+}
+
+// Renew replaces an invalid token, refreshing it if possible, or else
+// authorizing it again. Concurrent calls share one renewal, which carries on
+// when ctx is done. Tokens are handed out as usual meanwhile, and the new
+// one is swapped in at the end.
+func (r *RefreshingTokenSource) Renew(ctx context.Context) error {
+	r.mu.Lock()
+	call := r.renewal
+	if call == nil {
+		call = &renewal{done: make(chan struct{})}
+		r.renewal = call
+		go r.renew(call, r.current)
+	}
+	r.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-call.done:
+		return call.err
+	}
+}
+
+func (r *RefreshingTokenSource) renew(call *renewal, stale *oauth2.Token) {
+	defer close(call.done)
+	oauthConf := createOauthClient(r.conf, r.grant.Scopes)
+	// without an access token, the refresh token is used right away
+	token, err := oauthConf.TokenSource(r.ctx, &oauth2.Token{RefreshToken: stale.RefreshToken}).Token()
+	refreshed := err == nil
+	if !refreshed {
+		r.logger.Warn("Failed to refresh token, authorizing again", "err", err)
+		// fetchTokenFromServer saves the token itself
+		token, err = fetchTokenFromServer(r.ctx, r.conf, r.store, r.grant, r.logger)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.renewal = nil
+	if err != nil {
+		call.err = err
+		return
+	}
+	r.source = oauthConf.TokenSource(r.ctx, token)
+	if refreshed {
+		r.replace(token)
+	} else {
+		r.current = token
+		if r.onRefresh != nil {
+			r.onRefresh(token)
+		}
+	}
+}
+
+// missingScopes lists the wanted scopes that were not granted.
+func missingScopes(wanted []string, granted []string) []string {
+	var missing []string
+	for _, scope := range wanted {
+		if !slices.Contains(granted, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// keepTokenValid validates the token every hour until ctx is done.
+func keepTokenValid(ctx context.Context, source *RefreshingTokenSource, logger *slog.Logger) {
+	ticker := time.NewTicker(validateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := source.Validate(ctx); err != nil {
+				logger.Error("Failed to validate token", "err", err)
+			}
+		}
+	}
+}
+
+// keepTokenFresh refreshes the token shortly before it expires, even when no
+// API requests are being made, until ctx is done.
+func keepTokenFresh(ctx context.Context, source oauth2.TokenSource, logger *slog.Logger) {
+	for {
+		wait := time.Minute
+		token, err := source.Token()
+		if err != nil {
+			logger.Warn("Failed to refresh token", "err", err)
+		} else if token.Expiry.IsZero() {
+			return // token never expires
+		} else if untilRefresh := time.Until(token.Expiry) - 5*time.Second; untilRefresh > time.Second {
+			// oauth2 refreshes tokens within 10 seconds of expiring
+			wait = untilRefresh
+		} else {
+			wait = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// NewAuthClient adds source's token to every request. Unlike
+// oauth2.NewClient, it does not cache the token, so that a renewed one is
+// used right away.
+func NewAuthClient(source oauth2.TokenSource) *http.Client {
+	return &http.Client{Transport: &oauth2.Transport{Source: source}}
 }
 
 // Returns an authentication code that may be used to request an OAuth token
-func authenticate(conf oauth2.Config) (authCode string, err error) {
+func authenticate(ctx context.Context, conf oauth2.Config, logger *slog.Logger) (string, error) {
 	csrfToken, err := internal.GenerateRandomStringURLSafe(16)
 	if err != nil {
-		return
+		return "", err
+	}
+	// force_verify lets whoever is authorizing switch to the right account
+	authCodeURL := conf.AuthCodeURL(csrfToken, oauth2.SetAuthURLParam("force_verify", "true"))
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	report := func(r result) {
+		select {
+		case results <- r:
+		default: // already answered
+		}
 	}
-	authCodeURL := conf.AuthCodeURL(csrfToken)
-	wg := sync.WaitGroup{}
-	wg.Add(1)
 	authCallbackHandler := func(w http.ResponseWriter, r *http.Request) {
 		values := r.URL.Query()
 		if csrfToken != values.Get("state") {
-			panic("possible CSRF attack")
+			http.Error(w, "State mismatch, please try again.", http.StatusBadRequest)
+			report(result{err: fmt.Errorf("%w: state mismatch, possible CSRF attack", ErrAuth)})
+			return
+		}
+		if reason := values.Get("error"); reason != "" {
+			http.Error(w, "Authorization was not granted.", http.StatusForbidden)
+			report(result{err: fmt.Errorf("%w: %s: %s", ErrAuth, reason, values.Get("error_description"))})
+			return
 		}
-		authCode = values.Get("code")
 		_, _ = io.WriteString(w, "Authorization code stored successfully. You may now close this page.")
-		wg.Done()
+		report(result{code: values.Get("code")})
 	}
 	redirectURL, err := url.Parse(conf.RedirectURL)
 	if err != nil {
-		return
+		return "", err
 	}
 	listener, err := net.Listen("tcp", redirectURL.Host)
 	if err != nil {
-		return
+		return "", err
 	}
-	server := &http.Server{Addr: listener.Addr().String()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+strings.TrimPrefix(redirectURL.Path, "/"), authCallbackHandler)
+	server := &http.Server{Handler: mux}
 	defer server.Close()
-	http.HandleFunc("/"+redirectURL.Path, authCallbackHandler)
 	go func() {
 		if err := server.Serve(listener); err != http.ErrServerClosed {
-			panic(err)
+			report(result{err: err})
 		}
 	}()
 
-	fmt.Printf("Visit this URL to auth: %s\n", authCodeURL)
-	wg.Wait()
-	return
+	logger.Info("Visit this URL and log in as the account to authorize", "url", authCodeURL)
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-results:
+		return r.code, r.err
+	}
+}
+
+// authenticateDevice uses the device code grant flow, so the bot can be
+// authorized from a browser on another machine.
+// https://dev.twitch.tv/docs/authentication/getting-tokens-oauth/#device-code-grant-flow
+func authenticateDevice(ctx context.Context, conf oauth2.Config, logger *slog.Logger) (*oauth2.Token, error) {
+	// Twitch names the parameter scopes, and wants the secret from
+	// confidential clients
+	opts := []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("scopes", strings.Join(conf.Scopes, " "))}
+	if conf.ClientSecret != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("client_secret", conf.ClientSecret))
+	}
+	auth, err := conf.DeviceAuth(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Enter the code, logged in as the account to authorize", "url", auth.VerificationURI, "code", auth.UserCode, "expiry", auth.Expiry)
+	for {
+		// each call waits out the polling interval before asking
+		token, err := conf.DeviceAccessToken(ctx, auth, opts...)
+		if err == nil {
+			return token, nil
+		}
+		var retrieveErr *oauth2.RetrieveError
+		if !errors.As(err, &retrieveErr) {
+			return nil, err
+		}
+		// Twitch puts the error code in message, where oauth2 does not look
+		var body struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(retrieveErr.Body, &body)
+		switch body.Message {
+		case "authorization_pending":
+		case "slow_down":
+			auth.Interval += 5
+		default:
+			return nil, err
+		}
+	}
 }
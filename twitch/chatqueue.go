@@ -0,0 +1,210 @@
+package twitch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Chat limits for accounts that are not verified bots.
+// https://dev.twitch.tv/docs/irc/#rate-limits
+const (
+	chatWindow       = 30 * time.Second
+	chatLimit        = 20
+	chatLimitMod     = 100
+	chatChannelDelay = time.Second
+	// Twitch rejects a message identical to the previous one sent to the
+	// same channel within this long, unless the sender is a moderator.
+	chatDuplicateWindow = 30 * time.Second
+	// chatMaxPending bounds the queue so a flood of commands cannot back up
+	// replies for minutes.
+	chatMaxPending = 50
+)
+
+// ChatStats counts outbound chat messages since the client started.
+type ChatStats struct {
+	// Queued is how many messages are waiting to be sent.
+	Queued int
+	Sent   int
+	// Dropped counts messages discarded because the queue was full or
+	// Twitch would have rejected them as duplicates.
+	Dropped int
+	// Coalesced counts messages identical to one already waiting.
+	Coalesced int
+}
+
+type chatMessage struct {
+	channel string
+	text    string
+}
+
+type sentMessage struct {
+	text string
+	at   time.Time
+}
+
+// chatQueue sends chat messages no faster than Twitch allows, which depends
+// on whether the bot moderates the channel being sent to.
+type chatQueue struct {
+	say func(channel string, text string)
+
+	mu       sync.Mutex
+	pending  []chatMessage
+	sent     []time.Time
+	lastSent map[string]sentMessage
+	mods     map[string]bool
+	stats    ChatStats
+	wake     chan struct{}
+}
+
+func newChatQueue(say func(channel string, text string)) *chatQueue {
+	return &chatQueue{
+		say:      say,
+		lastSent: map[string]sentMessage{},
+		mods:     map[string]bool{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Say queues text for channel, without the leading #.
+func (q *chatQueue) Say(channel string, text string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, msg := range q.pending {
+		if msg.channel == channel && msg.text == text {
+			q.stats.Coalesced++
+			return
+		}
+	}
+	if len(q.pending) >= chatMaxPending {
+		q.stats.Dropped++
+		return
+	}
+	q.pending = append(q.pending, chatMessage{channel: channel, text: text})
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SetModerator records whether the bot moderates channel, as reported by
+// USERSTATE.
+func (q *chatQueue) SetModerator(channel string, mod bool) {
+	q.mu.Lock()
+	q.mods[channel] = mod
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *chatQueue) Stats() ChatStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Queued = len(q.pending)
+	return stats
+}
+
+// Wait waits until no messages are queued, or ctx is done.
+func (q *chatQueue) Wait(ctx context.Context) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for q.Stats().Queued > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run sends queued messages until ctx is done.
+func (q *chatQueue) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		msg, wait, ok := q.next(time.Now())
+		if ok {
+			q.say(msg.channel, msg.text)
+			continue
+		}
+		var retry <-chan time.Time
+		if wait > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			retry = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-retry:
+		}
+	}
+}
+
+// next takes the first message that may be sent now, keeping each channel's
+// messages in order. Otherwise it reports how long until one may be sent,
+// or 0 when nothing is waiting.
+func (q *chatQueue) next(now time.Time) (msg chatMessage, wait time.Duration, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.sent) > 0 && now.Sub(q.sent[0]) >= chatWindow {
+		q.sent = q.sent[1:]
+	}
+	blocked := map[string]bool{}
+	for i := 0; i < len(q.pending); i++ {
+		msg := q.pending[i]
+		if blocked[msg.channel] {
+			continue
+		}
+		mod := q.mods[msg.channel]
+		last := q.lastSent[msg.channel]
+		if !mod && msg.text == last.text && now.Sub(last.at) < chatDuplicateWindow {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.stats.Dropped++
+			i--
+			continue
+		}
+		if delay := q.delay(msg.channel, mod, now); delay > 0 {
+			blocked[msg.channel] = true
+			if wait == 0 || delay < wait {
+				wait = delay
+			}
+			continue
+		}
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+		q.sent = append(q.sent, now)
+		q.lastSent[msg.channel] = sentMessage{text: msg.text, at: now}
+		q.stats.Sent++
+		return msg, 0, true
+	}
+	return chatMessage{}, wait, false
+}
+
+// delay is how long until the bot may send to channel.
+func (q *chatQueue) delay(channel string, mod bool, now time.Time) time.Duration {
+	limit := chatLimit
+	if mod {
+		limit = chatLimitMod
+	}
+	var delay time.Duration
+	if len(q.sent) >= limit {
+		delay = q.sent[len(q.sent)-limit].Add(chatWindow).Sub(now)
+	}
+	if !mod {
+		if last, ok := q.lastSent[channel]; ok {
+			if channelDelay := last.at.Add(chatChannelDelay).Sub(now); channelDelay > delay {
+				delay = channelDelay
+			}
+		}
+	}
+	return delay
+}
@@ -0,0 +1,207 @@
+package twitch
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// chatStep calls next at an offset from the start of a test, expecting
+// either a message, given as "channel text", or how long to wait.
+type chatStep struct {
+	at   time.Duration
+	want string
+	wait time.Duration
+}
+
+func TestChatQueueNext(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		mods []string
+		// sent is how many messages went out at start, and lastSent what
+		// was last sent to each channel then
+		sent     int
+		lastSent map[string]string
+		say      []chatMessage
+		steps    []chatStep
+		dropped  int
+	}{
+		{
+			name: "channels in order",
+			say:  []chatMessage{{"a", "one"}, {"b", "two"}},
+			steps: []chatStep{
+				{want: "a one"},
+				{want: "b two"},
+				{wait: 0},
+			},
+		},
+		{
+			name: "a second apart in a channel",
+			say:  []chatMessage{{"a", "one"}, {"a", "two"}},
+			steps: []chatStep{
+				{want: "a one"},
+				{wait: time.Second},
+				{at: 500 * time.Millisecond, wait: 500 * time.Millisecond},
+				{at: time.Second, want: "a two"},
+			},
+		},
+		{
+			name: "other channels go ahead of a delayed one",
+			say:  []chatMessage{{"a", "one"}, {"a", "two"}, {"b", "three"}},
+			steps: []chatStep{
+				{want: "a one"},
+				{want: "b three"},
+				{wait: time.Second},
+			},
+		},
+		{
+			name: "no channel delay when moderating",
+			mods: []string{"a"},
+			say:  []chatMessage{{"a", "one"}, {"a", "two"}},
+			steps: []chatStep{
+				{want: "a one"},
+				{want: "a two"},
+			},
+		},
+		{
+			name: "shared bucket full",
+			sent: chatLimit,
+			say:  []chatMessage{{"a", "one"}},
+			steps: []chatStep{
+				{at: 10 * time.Second, wait: 20 * time.Second},
+				{at: chatWindow, want: "a one"},
+			},
+		},
+		{
+			name: "moderated channels get a bigger bucket",
+			mods: []string{"a"},
+			sent: chatLimit,
+			say:  []chatMessage{{"a", "one"}, {"b", "two"}},
+			steps: []chatStep{
+				{want: "a one"},
+				{at: 10 * time.Second, wait: 20 * time.Second},
+			},
+		},
+		{
+			name: "moderator bucket full",
+			mods: []string{"a"},
+			sent: chatLimitMod,
+			say:  []chatMessage{{"a", "one"}},
+			steps: []chatStep{
+				{at: 29 * time.Second, wait: time.Second},
+				{at: chatWindow, want: "a one"},
+			},
+		},
+		{
+			name:     "duplicate of the previous message dropped",
+			lastSent: map[string]string{"a": "hi"},
+			say:      []chatMessage{{"a", "hi"}, {"b", "hi"}},
+			steps: []chatStep{
+				{at: 5 * time.Second, want: "b hi"},
+				{at: 5 * time.Second, wait: 0},
+			},
+			dropped: 1,
+		},
+		{
+			name:     "duplicate sent after the window",
+			lastSent: map[string]string{"a": "hi"},
+			say:      []chatMessage{{"a", "hi"}},
+			steps: []chatStep{
+				{at: chatDuplicateWindow, want: "a hi"},
+			},
+		},
+		{
+			name:     "duplicate sent when moderating",
+			mods:     []string{"a"},
+			lastSent: map[string]string{"a": "hi"},
+			say:      []chatMessage{{"a", "hi"}},
+			steps: []chatStep{
+				{at: 5 * time.Second, want: "a hi"},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			q := newChatQueue(nil)
+			for _, channel := range test.mods {
+				q.SetModerator(channel, true)
+			}
+			for i := 0; i < test.sent; i++ {
+				q.sent = append(q.sent, start)
+			}
+			for channel, text := range test.lastSent {
+				q.lastSent[channel] = sentMessage{text: text, at: start}
+			}
+			for _, msg := range test.say {
+				q.Say(msg.channel, msg.text)
+			}
+			for i, step := range test.steps {
+				msg, wait, ok := q.next(start.Add(step.at))
+				switch {
+				case step.want != "" && !ok:
+					t.Fatalf("step %d: waiting %v, want %q sent", i, wait, step.want)
+				case step.want != "" && msg.channel+" "+msg.text != step.want:
+					t.Fatalf("step %d: sent %q to %s, want %q", i, msg.text, msg.channel, step.want)
+				case step.want == "" && ok:
+					t.Fatalf("step %d: sent %q to %s, want to wait %v", i, msg.text, msg.channel, step.wait)
+				case step.want == "" && wait != step.wait:
+					t.Fatalf("step %d: waiting %v, want %v", i, wait, step.wait)
+				}
+			}
+			if dropped := q.Stats().Dropped; dropped != test.dropped {
+				t.Errorf("dropped %d messages, want %d", dropped, test.dropped)
+			}
+		})
+	}
+}
+
+func TestChatQueueSay(t *testing.T) {
+	tests := []struct {
+		name      string
+		say       []chatMessage
+		queued    int
+		coalesced int
+		dropped   int
+	}{
+		{
+			name:   "different messages",
+			say:    []chatMessage{{"a", "one"}, {"a", "two"}, {"b", "one"}},
+			queued: 3,
+		},
+		{
+			name:      "identical messages coalesced",
+			say:       []chatMessage{{"a", "one"}, {"a", "one"}, {"a", "one"}},
+			queued:    1,
+			coalesced: 2,
+		},
+		{
+			name:    "full queue",
+			say:     numberedMessages(chatMaxPending + 2),
+			queued:  chatMaxPending,
+			dropped: 2,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			q := newChatQueue(nil)
+			for _, msg := range test.say {
+				q.Say(msg.channel, msg.text)
+			}
+			want := ChatStats{Queued: test.queued, Coalesced: test.coalesced, Dropped: test.dropped}
+			if stats := q.Stats(); stats != want {
+				t.Errorf("got %+v, want %+v", stats, want)
+			}
+		})
+	}
+}
+
+func numberedMessages(n int) []chatMessage {
+	var msgs []chatMessage
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, chatMessage{"a", strconv.Itoa(i)})
+	}
+	return msgs
+}
@@ -3,194 +3,311 @@ package twitch
 import (
 	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"math/rand"
-	"net/http"
-	"net/url"
+	"log/slog"
+	"time"
 
+	"github.com/kevinkjt2000/twitch-go-bot/commands"
 	"github.com/spddl/go-twitch-ws"
 	"golang.org/x/oauth2"
-	otwitch "golang.org/x/oauth2/twitch"
 )
 
 type Event interface{}
 
+// Client talks to Twitch for the bot. Methods that call Twitch take a
+// context; Say only queues a message, and the rest return immediately.
 type Client interface {
 	Close()
-	GetBroadcasterId(username string) (string, error)
-	Reconnect()
-	SubscribeToEvent(broadcasterId string, sessionId string) error
+	GetBroadcasterId(ctx context.Context, username string) (string, error)
+	Say(channel string, message string)
+	ChatStats() ChatStats
+	SubscribeToEvent(ctx context.Context, subscriptionType string, broadcasterId string, sessionId string) error
+	UpdateRedemptionStatus(ctx context.Context, broadcasterId string, rewardId string, redemptionId string, status string) error
 }
 
+// Channel points redemption statuses
+const (
+	RedemptionUnfulfilled = "unfulfilled"
+	RedemptionFulfilled   = "FULFILLED"
+	RedemptionCanceled    = "CANCELED"
+)
+
 type websocketClient struct {
-	config     Config
-	httpClient *http.Client
-	ircClient  *twitch.Client
+	// helix uses the bot's token, and broadcasters each broadcaster's,
+	// keyed by broadcaster id
+	helix        *Helix
+	broadcasters map[string]*Helix
+	irc          *ircConn
+	chat         *chatQueue
+	stopChat     context.CancelFunc
 }
 
-// Reconnect asynchronously attempts to re-establish connection.
-func (w websocketClient) Reconnect() {
-	w.ircClient.CloseAndReconnect()
+// closeTimeout bounds how long Close waits for queued chat messages.
+const closeTimeout = 5 * time.Second
+
+// Say queues a chat message to channel, without the leading #.
+func (w websocketClient) Say(channel string, message string) {
+	w.chat.Say(channel, message)
 }
 
-func (w websocketClient) doRequest(method string, url string, body io.Reader) ([]byte, int, error) {
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, 0, err
-	}
-	req.Header.Set("Client-Id", w.config.ClientId)
-	if body != nil {
-		req.Header.Set("Content-Type", "application/json")
-	}
-	resp, err := w.httpClient.Do(req)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer resp.Body.Close()
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, resp.StatusCode, err
+// ChatStats reports how many chat messages are queued, sent, and dropped.
+func (w websocketClient) ChatStats() ChatStats {
+	return w.chat.Stats()
+}
+
+// broadcasterHelix returns the API client using the broadcaster's own token,
+// which only channels with subscriptions have.
+func (w websocketClient) broadcasterHelix(broadcasterId string) (*Helix, error) {
+	helix, ok := w.broadcasters[broadcasterId]
+	if !ok {
+		return nil, fmt.Errorf("twitch: no token for broadcaster %s, whose channel has no subscriptions", broadcasterId)
 	}
-	return data, resp.StatusCode, nil
+	return helix, nil
 }
 
+// Close disconnects once queued chat messages are sent, such as refund
+// notices for redemptions interrupted by disconnecting, waiting at most
+// closeTimeout.
 func (w websocketClient) Close() {
-	w.ircClient.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	w.chat.Wait(ctx)
+	cancel()
+	w.stopChat()
+	w.irc.Close()
 }
 
-func (w websocketClient) GetBroadcasterId(username string) (string, error) {
-	Url, err := url.Parse("https://api.twitch.tv/helix/users")
+func (w websocketClient) GetBroadcasterId(ctx context.Context, username string) (string, error) {
+	users, err := w.helix.GetUsers(ctx, username)
 	if err != nil {
 		return "", err
 	}
-	params := url.Values{}
-	params.Add("login", username)
-	Url.RawQuery = params.Encode()
-	data, _, err := w.doRequest("GET", Url.String(), nil)
-	if err != nil {
-		return "", err
+	if len(users) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrUnknownUser, username)
 	}
-	var users UsersData
-	err = json.Unmarshal(data, &users)
+	return users[0].Id, nil
+}
+
+func (w websocketClient) SubscribeToEvent(ctx context.Context, subscriptionType string, broadcasterId string, sessionId string) error {
+	subType, err := LookupSubscriptionType(subscriptionType)
 	if err != nil {
-		return "", err
+		return err
 	}
-	if len(users.Data) == 0 {
-		return "", errors.New("twitch: no matching users")
+	helix, err := w.broadcasterHelix(broadcasterId)
+	if err != nil {
+		return err
 	}
-	return users.Data[0].Id, nil
-}
-
-func (w websocketClient) SubscribeToEvent(broadcasterId string, sessionId string) error {
-	var buf bytes.Buffer
-	_ = json.NewEncoder(&buf).Encode(Subscription{
-		Condition: SubscriptionCondition{
-			BroadcasterUserId: broadcasterId,
-		},
+	_, err = helix.CreateEventSubSubscription(ctx, Subscription{
+		Condition: subType.Condition(broadcasterId),
 		Transport: SubscriptionTransport{
 			Method:    "websocket",
 			SessionId: sessionId,
 		},
-		Type:    "channel.channel_points_custom_reward_redemption.add",
-		Version: "1",
+		Type:    subscriptionType,
+		Version: subType.Version,
 	})
-	_, status, err := w.doRequest("POST", "https://api.twitch.tv/helix/eventsub/subscriptions", &buf)
+	return err
+}
+
+// UpdateRedemptionStatus marks a redemption FULFILLED or CANCELED; canceling
+// refunds the viewer's channel points.
+func (w websocketClient) UpdateRedemptionStatus(ctx context.Context, broadcasterId string, rewardId string, redemptionId string, status string) error {
+	helix, err := w.broadcasterHelix(broadcasterId)
 	if err != nil {
 		return err
 	}
-	if status != http.StatusAccepted {
-		return fmt.Errorf("twitch: failed subscription %d", status)
-	}
-	return nil
+	_, err = helix.UpdateRedemptionStatus(ctx, broadcasterId, rewardId, status, redemptionId)
+	return err
 }
 
-func NewClient(ctx context.Context, conf Config) (Client, error) {
-	token, err := AcquireToken(ctx, conf)
+// NewClient joins every configured channel as conf.BotLogin, answering chat
+// commands from the registry for each channel, keyed by channel name.
+// Channels with subscriptions also need their broadcaster's token, which is
+// used for EventSub and channel point rewards. Tokens are kept in store.
+func NewClient(ctx context.Context, conf Config, store TokenStore, registries map[string]*commands.Registry, logger *slog.Logger) (Client, error) {
+	botGrant := BotGrant(conf)
+	token, err := AcquireToken(ctx, conf, store, botGrant, logger)
 	if err != nil {
 		return nil, err
 	}
-	oauthClient, err := NewAuthClient(ctx, conf, token)
-	if err != nil {
+	tokenLogger := logger.With("token", botGrant.Name)
+	var chat *chatQueue
+	irc := newIRCConn(func(token string) (*twitch.Client, error) {
+		ircClient, err := twitch.NewClient(&twitch.Client{
+			Server:      conf.IRCURL,
+			User:        conf.BotLogin,
+			Oauth:       token, // without "oauth:" https://twitchapps.com/tmi/
+			Debug:       false,
+			BotVerified: false, // verified bots: Have higher chat limits than regular users.
+			Channel:     conf.JoinedChannels(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		ircClient.OnUserStateMessage = func(msg twitch.IRCMessage) {
+			if len(msg.Params) == 0 || len(msg.Params[0]) < 2 {
+				return
+			}
+			channel := string(msg.Params[0][1:])
+			mod := string(msg.Tags["mod"]) == "1"
+			for _, badge := range parseBadges(msg.Tags["badges"]) {
+				mod = mod || badge == "broadcaster" || badge == "moderator"
+			}
+			chat.SetModerator(channel, mod)
+		}
+		ircClient.OnConnect = func(connected bool) {
+			logger.Info("IRC connection changed", "connected", connected)
+		}
+		ircClient.OnPrivateMessage = func(msg twitch.IRCMessage) {
+			channel := string(msg.Params[0][1:])
+			msgline := msg.Params[1]
+			squashedMsgline := bytes.ReplaceAll(msgline, []byte(" "), []byte(""))
+			if bytes.Contains(squashedMsgline, []byte("(╯°□°)╯︵┻━┻")) || bytes.Contains(squashedMsgline, []byte("(╯°□°）╯︵┻━┻")) {
+				logger.Info("Table flipping detected, flipping back", "channel", channel, "user", string(msg.Tags["display-name"]))
+				chat.Say(channel, "┬─┬ ノ( ゜-゜ノ)")
+				return
+			}
+			registry, ok := registries[channel]
+			if !ok {
+				return
+			}
+			cmd, args, ok := registry.Lookup(string(msgline))
+			if !ok {
+				return
+			}
+			cmdCtx := commands.Context{
+				Channel: channel,
+				User:    string(msg.Tags["display-name"]),
+				UserId:  string(msg.Tags["user-id"]),
+				Badges:  parseBadges(msg.Tags["badges"]),
+				Args:    args,
+			}
+			if !registry.Allow(cmd, cmdCtx) {
+				return
+			}
+			response, err := cmd.Handler(cmdCtx)
+			if err != nil {
+				logger.Error("Command failed", "command", cmd.Name, "channel", channel, "user", cmdCtx.User, "err", err)
+				return
+			}
+			if response != "" {
+				chat.Say(channel, response)
+			}
+		}
+		ircClient.Run()
+		return ircClient, nil
+	}, logger)
+	// chat enforces Twitch's limits itself, so messages skip the IRC
+	// library's own limiter, which assumes the bot is never a moderator
+	chat = newChatQueue(irc.Say)
+	tokenSource := NewTokenSource(ctx, conf, store, botGrant, token, func(refreshed *oauth2.Token) {
+		tokenLogger.Info("Token refreshed", "expiry", refreshed.Expiry)
+		irc.Reconnect(refreshed.AccessToken)
+	}, tokenLogger)
+	// Twitch wants tokens validated on startup, which also catches revoked
+	// ones before IRC logs in with them
+	if err := tokenSource.Validate(ctx); err != nil {
+		return nil, err
+	}
+	if token, err = tokenSource.Token(); err != nil {
+		return nil, err
+	}
+	if err := irc.Connect(token.AccessToken); err != nil {
 		return nil, err
 	}
 
-	ircClient, err := twitch.NewClient(&twitch.Client{
-		Server:      "wss://irc-ws.chat.twitch.tv",
-		User:        "shinybotwatch",
-		Oauth:       token.AccessToken, // without "oauth:" https://twitchapps.com/tmi/
-		Debug:       false,
-		BotVerified: false, // verified bots: Have higher chat limits than regular users.
-		Channel:     []string{"shinybucket_"},
-	})
+	go keepTokenFresh(ctx, tokenSource, tokenLogger)
+	go keepTokenValid(ctx, tokenSource, tokenLogger)
+	client := &websocketClient{
+		helix:        newRenewingHelix(conf, tokenSource),
+		broadcasters: map[string]*Helix{},
+		irc:          irc,
+		chat:         chat,
+	}
+	for _, channel := range conf.Channels {
+		if len(channel.Subscriptions) == 0 {
+			continue
+		}
+		helix, err := newBroadcasterHelix(ctx, conf, store, BroadcasterGrant(channel.Name), logger)
+		if err != nil {
+			irc.Close()
+			return nil, err
+		}
+		broadcasterId, err := client.GetBroadcasterId(ctx, channel.Name)
+		if err != nil {
+			irc.Close()
+			return nil, err
+		}
+		client.broadcasters[broadcasterId] = helix
+	}
+	// chat keeps going after ctx is done, until Close has sent what is left
+	var chatCtx context.Context
+	chatCtx, client.stopChat = context.WithCancel(context.WithoutCancel(ctx))
+	go chat.Run(chatCtx)
+	return client, nil
+}
+
+func newBroadcasterHelix(ctx context.Context, conf Config, store TokenStore, grant Grant, logger *slog.Logger) (*Helix, error) {
+	token, err := AcquireToken(ctx, conf, store, grant, logger)
 	if err != nil {
 		return nil, err
 	}
-	ircClient.OnConnect = func(connected bool) {
-		fmt.Printf("Connecting to IRC with %v\n", connected)
-	}
-	ircClient.OnPrivateMessage = func(msg twitch.IRCMessage) {
-		channel := msg.Params[0][1:]
-		msgline := msg.Params[1]
-		squashedMsgline := bytes.ReplaceAll(msgline, []byte(" "), []byte(""))
-		if bytes.Contains(squashedMsgline, []byte("(╯°□°)╯︵┻━┻")) || bytes.Contains(squashedMsgline, []byte("(╯°□°）╯︵┻━┻")) {
-			fmt.Println("Table flipping detected... flipping back")
-			ircClient.Say(string(channel), "┬─┬ ノ( ゜-゜ノ)", false)
-		} else if bytes.Equal(squashedMsgline, []byte("!discord")) {
-			ircClient.Say(string(channel), "https://discord.gg/4FnuP7PEva", false)
-		} else if bytes.Equal(squashedMsgline, []byte("!modpack")) {
-			ircClient.Say(string(channel), "This is GregTech New Horizons, a modpack with hundreds of mods. https://wiki.gtnewhorizons.com", false)
-		} else if bytes.Equal(squashedMsgline, []byte("!shaders")) {
-			ircClient.Say(string(channel), "Complementary v5.6.1 https://gtnh.miraheze.org/wiki/shader", false)
-		} else if bytes.Equal(squashedMsgline, []byte("!textures")) {
-			ircClient.Say(string(channel), "Using Faithful 32x, outlined ores, and Usernm0 circuits from https://gtnh.miraheze.org/wiki/Resource_Packs", false)
-		} else if bytes.Equal(squashedMsgline, []byte("!youtube")) {
-			ircClient.Say(string(channel), "http://www.youtube.com/@shinybucket", false)
-		} else if bytes.Equal(squashedMsgline, []byte("!8ball")) {
-			ircClient.Say(string(channel), randomEightBallMessage(), false)
-		}
+	tokenLogger := logger.With("token", grant.Name)
+	tokenSource := NewTokenSource(ctx, conf, store, grant, token, func(refreshed *oauth2.Token) {
+		tokenLogger.Info("Token refreshed", "expiry", refreshed.Expiry)
+	}, tokenLogger)
+	if err := tokenSource.Validate(ctx); err != nil {
+		return nil, err
 	}
-	ircClient.Run()
-	return &websocketClient{
-		config:     conf,
-		httpClient: oauthClient,
-		ircClient:  ircClient,
-	}, nil
+	go keepTokenFresh(ctx, tokenSource, tokenLogger)
+	go keepTokenValid(ctx, tokenSource, tokenLogger)
+	return newRenewingHelix(conf, tokenSource), nil
+}
+
+// newRenewingHelix calls the API with source's token, renewing it when
+// Twitch rejects it.
+func newRenewingHelix(conf Config, source *RefreshingTokenSource) *Helix {
+	helix := NewHelix(conf.HelixURL, conf.ClientId, NewAuthClient(source))
+	helix.renew = source.Renew
+	return helix
 }
 
-func randomEightBallMessage() string {
-	messages := []string{
-		"It is certain.", "It is decidely so.",
-		"Without a doubt.", "Yes – definitely.", "You may rely on it.", "As I see it, yes.", "Most likely.", "Outlook good.", "Yes.", "Signs point to yes.",
-		"Reply hazy, try again.", "Ask again later.", "Better not tell you now.", "Cannot predict now.", "Concentrate and ask again.",
-		"Don’t count on it.", "My reply is no.", "My sources say no.", "Outlook not so good.", "Very doubtful.",
+// parseBadges turns a badges tag like "broadcaster/1,subscriber/12" into
+// badge names.
+func parseBadges(tag []byte) []string {
+	var badges []string
+	for _, badge := range bytes.Split(tag, []byte(",")) {
+		name, _, _ := bytes.Cut(badge, []byte("/"))
+		if len(name) > 0 {
+			badges = append(badges, string(name))
+		}
 	}
-	return messages[rand.Intn(len(messages))]
+	return badges
 }
 
-func createOauthClient(conf Config) oauth2.Config {
+func createOauthClient(conf Config, scopes []string) oauth2.Config {
 	oauthConf := oauth2.Config{
 		ClientID:     conf.ClientId,
 		ClientSecret: conf.ClientSecret,
-		Endpoint:     otwitch.Endpoint,
-		RedirectURL:  "http://localhost:3000",
-		Scopes: []string{
-			// https://dev.twitch.tv/docs/authentication/scopes/
-			"channel:manage:redemptions",
-			"chat:edit",
-			"chat:read",
-			"channel:moderate",
-			"whispers:read",
-			"whispers:edit",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       conf.AuthURL + "/authorize",
+			DeviceAuthURL: conf.AuthURL + "/device",
+			TokenURL:      conf.AuthURL + "/token",
+			AuthStyle:     oauth2.AuthStyleInParams,
 		},
+		RedirectURL: "http://localhost:3000",
+		Scopes:      scopes,
 	}
 	return oauthConf
 }
 
 type SubscriptionCondition struct {
-	BroadcasterUserId string `json:"broadcaster_user_id,omitempty"`
+	BroadcasterId         string `json:"broadcaster_id,omitempty"`
+	BroadcasterUserId     string `json:"broadcaster_user_id,omitempty"`
+	FromBroadcasterUserId string `json:"from_broadcaster_user_id,omitempty"`
+	ToBroadcasterUserId   string `json:"to_broadcaster_user_id,omitempty"`
+	ModeratorUserId       string `json:"moderator_user_id,omitempty"`
+	UserId                string `json:"user_id,omitempty"`
+	RewardId              string `json:"reward_id,omitempty"`
 }
 
 type SubscriptionTransport struct {
@@ -205,35 +322,6 @@ type Subscription struct {
 	Version   string                `json:"version"`
 }
 
-type SessionInfo struct {
-	Id                      string  `json:"id"`
-	Status                  string  `json:"status"`
-	ConnectedAt             string  `json:"connected_at"`
-	KeepaliveTimeoutSeconds int64   `json:"keepalive_timeout_seconds"`
-	ReconnectUrl            *string `json:"reconnect_url,omitempty"`
-}
-
-type WelcomeMessage struct {
-	Session SessionInfo `json:"session"`
-}
-
-type Metadata struct {
-	MessageId        string `json:"message_id"`
-	MessageType      string `json:"message_type"`
-	MessageTimestamp string `json:"message_timestamp"`
-	SubscriptionType string `json:"subscription_type,omitempty"`
-}
-
-type Message struct {
-	Metadata Metadata `json:"metadata"`
-	Payload  map[string]interface{}
-}
-
-type Config struct {
-	ClientId     string `env:"TWITCH_CLIENT_ID,required"`
-	ClientSecret string `env:"TWITCH_CLIENT_SECRET,required"`
-}
-
 type User struct {
 	Id    string `json:"id"`
 	Login string `json:"login"`
@@ -0,0 +1,325 @@
+package twitch_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevinkjt2000/twitch-go-bot/commands"
+	"github.com/kevinkjt2000/twitch-go-bot/eventsub"
+	"github.com/kevinkjt2000/twitch-go-bot/twitch"
+	"github.com/kevinkjt2000/twitch-go-bot/twitch/twitchtest"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testChannel       = "shinybucket_"
+	testBroadcasterId = "1"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// testConfig points the bot at server, joining testChannel with a follow
+// subscription, and saves tokens for both accounts to a new token file.
+func testConfig(t *testing.T, server *twitchtest.Server) (twitch.Config, twitch.TokenStore) {
+	t.Helper()
+	conf := server.Config()
+	conf.AuthFlow = twitch.AuthFlowDevice
+	conf.Channels = []twitch.ChannelConfig{{Name: testChannel, Subscriptions: []string{eventsub.ChannelFollow}}}
+	server.AddUser(testBroadcasterId, testChannel)
+	server.AddUser("2", conf.BotLogin)
+	store := twitch.NewFileStore(filepath.Join(t.TempDir(), "tokens"), "")
+	for _, grant := range []twitch.Grant{twitch.BotGrant(conf), twitch.BroadcasterGrant(testChannel)} {
+		if err := store.Save(grant.Name, server.IssueToken(grant.Login, grant.Scopes...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return conf, store
+}
+
+func newTestClient(t *testing.T, ctx context.Context, conf twitch.Config, store twitch.TokenStore, registries map[string]*commands.Registry) twitch.Client {
+	t.Helper()
+	client, err := twitch.NewClient(ctx, conf, store, registries, discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+// runEventSub starts an EventSub session subscribed to testChannel's
+// subscriptions, and waits for them to be created. It also returns a
+// function counting how many times the session has subscribed.
+func runEventSub(t *testing.T, ctx context.Context, conf twitch.Config, client twitch.Client) (*eventsub.Client, func() int) {
+	t.Helper()
+	subscribed := make(chan struct{}, 10)
+	eventsubClient := eventsub.NewClient(conf.EventSubURL, func(ctx context.Context, sessionId string) error {
+		for _, subscriptionType := range conf.Channels[0].Subscriptions {
+			if err := client.SubscribeToEvent(ctx, subscriptionType, testBroadcasterId, sessionId); err != nil {
+				return err
+			}
+		}
+		subscribed <- struct{}{}
+		return nil
+	}, discardLogger)
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- eventsubClient.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	select {
+	case <-subscribed:
+	case err := <-done:
+		t.Fatalf("EventSub stopped before subscribing: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timed out subscribing")
+	}
+	return eventsubClient, func() int { return 1 + len(subscribed) }
+}
+
+func nextEvent(t *testing.T, ctx context.Context, eventsubClient *eventsub.Client) eventsub.Message {
+	t.Helper()
+	select {
+	case msg := <-eventsubClient.Events():
+		return msg
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for an EventSub message")
+		return nil
+	}
+}
+
+func follow(user string) map[string]interface{} {
+	return map[string]interface{}{
+		"broadcaster_user_id":    testBroadcasterId,
+		"broadcaster_user_login": testChannel,
+		"user_id":                "3",
+		"user_login":             user,
+		"followed_at":            time.Now().UTC(),
+	}
+}
+
+func assertFollow(t *testing.T, msg eventsub.Message, user string) {
+	t.Helper()
+	notification, ok := msg.(*eventsub.NotificationMessage)
+	if !ok {
+		t.Fatalf("got %T, want a notification", msg)
+	}
+	event, ok := notification.Event.(*eventsub.FollowEvent)
+	if !ok {
+		t.Fatalf("got a %T event, want a follow", notification.Event)
+	}
+	if event.UserLogin != user || event.BroadcasterUserId != testBroadcasterId {
+		t.Errorf("got a follow from %s to %s, want one from %s to %s", event.UserLogin, event.BroadcasterUserId, user, testBroadcasterId)
+	}
+}
+
+func TestChatCommand(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	ctx := testContext(t)
+	conf, store := testConfig(t, server)
+	registry := commands.NewRegistry()
+	if err := registry.Add(commands.Command{Name: "ping", Response: "pong {{.User}}"}); err != nil {
+		t.Fatal(err)
+	}
+	newTestClient(t, ctx, conf, store, map[string]*commands.Registry{testChannel: registry})
+	if err := server.WaitForJoin(ctx, testChannel); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.SendChat(ctx, testChannel, "viewer", "!ping"); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := server.NextChat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Channel != testChannel || msg.Text != "pong viewer" {
+		t.Errorf("bot said %q in %s, want %q in %s", msg.Text, msg.Channel, "pong viewer", testChannel)
+	}
+}
+
+func TestEventSubNotification(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	ctx := testContext(t)
+	conf, store := testConfig(t, server)
+	client := newTestClient(t, ctx, conf, store, nil)
+	eventsubClient, _ := runEventSub(t, ctx, conf, client)
+
+	subscriptions := server.Subscriptions()
+	if len(subscriptions) != 1 || subscriptions[0].Type != eventsub.ChannelFollow || subscriptions[0].Condition.BroadcasterUserId != testBroadcasterId {
+		t.Fatalf("got subscriptions %+v, want one to %s for broadcaster %s", subscriptions, eventsub.ChannelFollow, testBroadcasterId)
+	}
+	if err := server.SendNotification(ctx, eventsub.ChannelFollow, follow("viewer")); err != nil {
+		t.Fatal(err)
+	}
+	assertFollow(t, nextEvent(t, ctx, eventsubClient), "viewer")
+}
+
+func TestEventSubReconnect(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	ctx := testContext(t)
+	conf, store := testConfig(t, server)
+	client := newTestClient(t, ctx, conf, store, nil)
+	eventsubClient, subscribeCalls := runEventSub(t, ctx, conf, client)
+	before := server.Subscriptions()
+
+	if err := server.SendReconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for server.EventSubConnections() < 2 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for the client to reconnect")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	// sent on the new connection, once it is welcomed
+	if err := server.SendNotification(ctx, eventsub.ChannelFollow, follow("viewer")); err != nil {
+		t.Fatal(err)
+	}
+	assertFollow(t, nextEvent(t, ctx, eventsubClient), "viewer")
+
+	if calls := subscribeCalls(); calls != 1 {
+		t.Errorf("subscribed %d times, want subscriptions kept across the reconnect", calls)
+	}
+	after := server.Subscriptions()
+	if len(after) != 1 || after[0].Transport.SessionId != before[0].Transport.SessionId {
+		t.Errorf("got subscriptions %+v after reconnecting, want %+v", after, before)
+	}
+}
+
+func TestEventSubRevocation(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	ctx := testContext(t)
+	conf, store := testConfig(t, server)
+	client := newTestClient(t, ctx, conf, store, nil)
+	eventsubClient, _ := runEventSub(t, ctx, conf, client)
+
+	if err := server.SendRevocation(ctx, eventsub.ChannelFollow, "authorization_revoked"); err != nil {
+		t.Fatal(err)
+	}
+	msg := nextEvent(t, ctx, eventsubClient)
+	revocation, ok := msg.(*eventsub.RevocationMessage)
+	if !ok {
+		t.Fatalf("got %T, want a revocation", msg)
+	}
+	if revocation.Subscription.Type != eventsub.ChannelFollow || revocation.Subscription.Status != "authorization_revoked" {
+		t.Errorf("got %s revoked with %s, want %s revoked with authorization_revoked", revocation.Subscription.Type, revocation.Subscription.Status, eventsub.ChannelFollow)
+	}
+	if subscriptions := server.Subscriptions(); len(subscriptions) != 0 {
+		t.Errorf("got subscriptions %+v after the revocation, want none", subscriptions)
+	}
+}
+
+func TestExpiredTokenIsRenewed(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	ctx := testContext(t)
+	conf, store := testConfig(t, server)
+	client := newTestClient(t, ctx, conf, store, nil)
+
+	server.ExpireTokens()
+	broadcasterId, err := client.GetBroadcasterId(ctx, testChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if broadcasterId != testBroadcasterId {
+		t.Errorf("got broadcaster id %s, want %s", broadcasterId, testBroadcasterId)
+	}
+}
+
+func TestTokenFromWrongAccount(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	ctx := testContext(t)
+	conf, store := testConfig(t, server)
+	if err := store.Save(twitch.BotGrant(conf).Name, server.IssueToken("someone_else", twitch.BotGrant(conf).Scopes...)); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := twitch.NewClient(ctx, conf, store, nil, discardLogger)
+	if err == nil {
+		client.Close()
+	}
+	if !errors.Is(err, twitch.ErrAuth) {
+		t.Errorf("got %v, want ErrAuth", err)
+	}
+}
+
+func TestRedemptionForChannelWithoutToken(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	ctx := testContext(t)
+	conf, store := testConfig(t, server)
+	client := newTestClient(t, ctx, conf, store, nil)
+
+	err := client.UpdateRedemptionStatus(ctx, "2", "reward-1", "redemption-1", twitch.RedemptionCanceled)
+	if err == nil {
+		t.Error("updated a redemption for a broadcaster without a token")
+	}
+}
+
+func TestAdBreakSubscription(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	ctx := testContext(t)
+	conf, store := testConfig(t, server)
+	conf.Channels[0].Subscriptions = []string{eventsub.ChannelAdBreakBegin}
+	client := newTestClient(t, ctx, conf, store, nil)
+	runEventSub(t, ctx, conf, client)
+
+	subscriptions := server.Subscriptions()
+	if len(subscriptions) != 1 || subscriptions[0].Condition != (twitch.SubscriptionCondition{BroadcasterId: testBroadcasterId}) {
+		t.Fatalf("got subscriptions %+v, want one with broadcaster_id %s", subscriptions, testBroadcasterId)
+	}
+}
+
+func TestRevokedTokenInEnvStore(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	ctx := testContext(t)
+	conf, _ := testConfig(t, server)
+	tokens := map[string]*oauth2.Token{}
+	for _, grant := range []twitch.Grant{twitch.BotGrant(conf), twitch.BroadcasterGrant(testChannel)} {
+		tokens[grant.Name] = server.IssueToken(grant.Login, grant.Scopes...)
+	}
+	tokensJSON, err := json.Marshal(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := twitch.NewEnvStore(string(tokensJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RevokeTokens()
+
+	client, err := twitch.NewClient(ctx, conf, store, nil, discardLogger)
+	if err == nil {
+		client.Close()
+	}
+	if !errors.Is(err, twitch.ErrReadOnlyStore) {
+		t.Errorf("got %v, want ErrReadOnlyStore", err)
+	}
+	if n := server.Authorizations(); n != 0 {
+		t.Errorf("started %d authorizations for tokens that cannot be saved", n)
+	}
+}
@@ -0,0 +1,192 @@
+package twitch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/caarlos0/env"
+	"github.com/kevinkjt2000/twitch-go-bot/eventsub"
+)
+
+// Config is read from an optional JSON file, with environment variables
+// taking precedence over the file.
+type Config struct {
+	ClientId     string `env:"TWITCH_CLIENT_ID" json:"client_id"`
+	ClientSecret string `env:"TWITCH_CLIENT_SECRET" json:"client_secret"`
+	// AuthFlow is AuthFlowBrowser or AuthFlowDevice.
+	AuthFlow string `env:"TWITCH_AUTH_FLOW" json:"auth_flow"`
+	// TokenStore is TokenStoreFile, TokenStoreKeyring, or TokenStoreEnv.
+	TokenStore string `env:"TWITCH_TOKEN_STORE" json:"token_store"`
+	TokenFile  string `env:"TWITCH_TOKEN_FILE" json:"token_file"`
+	// TokenPassphrase encrypts TokenFile. Like Tokens, it is only read from
+	// the environment.
+	TokenPassphrase string `env:"TWITCH_TOKEN_PASSPHRASE" json:"-"`
+	// Tokens is a JSON object of tokens by name for TokenStoreEnv.
+	Tokens string `env:"TWITCH_TOKENS" json:"-"`
+
+	// BotLogin is the account that chats on behalf of the bot.
+	BotLogin string `env:"TWITCH_BOT_LOGIN" json:"bot_login"`
+	// ChannelNames adds channels with default settings to Channels.
+	ChannelNames []string        `env:"TWITCH_CHANNELS" envSeparator:"," json:"-"`
+	Channels     []ChannelConfig `json:"channels"`
+
+	// CommandsFile and Subscriptions apply to channels that do not set their own.
+	CommandsFile  string   `env:"BOT_COMMANDS_FILE" json:"commands_file"`
+	Subscriptions []string `env:"BOT_SUBSCRIPTIONS" envSeparator:"," json:"subscriptions"`
+
+	// Base URLs are only overridden to point the bot at a fake Twitch,
+	// such as the one in the twitchtest package.
+	AuthURL     string `env:"TWITCH_AUTH_URL" json:"auth_url"`
+	HelixURL    string `env:"TWITCH_HELIX_URL" json:"helix_url"`
+	IRCURL      string `env:"TWITCH_IRC_URL" json:"irc_url"`
+	EventSubURL string `env:"TWITCH_EVENTSUB_URL" json:"eventsub_url"`
+}
+
+// ChannelConfig holds the settings for one channel the bot joins.
+type ChannelConfig struct {
+	// Name is the broadcaster's login.
+	Name          string   `json:"name"`
+	CommandsFile  string   `json:"commands_file,omitempty"`
+	Subscriptions []string `json:"subscriptions,omitempty"`
+	// TTSVoice is used for TTS messages without a [voice] tag instead of
+	// a random voice.
+	TTSVoice string `json:"tts_voice,omitempty"`
+}
+
+// Ways of authorizing the bot
+const (
+	// AuthFlowBrowser redirects a browser on the same machine to a listener
+	// on localhost:3000.
+	AuthFlowBrowser = "browser"
+	// AuthFlowDevice shows a code to enter at twitch.tv/activate from any
+	// device, for headless machines.
+	AuthFlowDevice = "device"
+)
+
+var defaultSubscriptions = []string{
+	eventsub.ChannelPointsRedemptionAdd,
+	eventsub.ChannelFollow,
+	eventsub.ChannelSubscribe,
+	eventsub.ChannelSubscriptionMessage,
+	eventsub.ChannelSubscriptionGift,
+	eventsub.ChannelCheer,
+	eventsub.ChannelRaid,
+	eventsub.StreamOnline,
+	eventsub.StreamOffline,
+}
+
+// LoadConfig reads the config file at path, if there is one, then applies
+// environment variables and defaults.
+func LoadConfig(path string) (Config, error) {
+	var conf Config
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &conf); err != nil {
+			return conf, fmt.Errorf("twitch: parsing %s: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return conf, err
+	}
+	if err := env.Parse(&conf); err != nil {
+		return conf, err
+	}
+	conf.setDefaults()
+	return conf, conf.validate()
+}
+
+func (c *Config) setDefaults() {
+	setDefault(&c.AuthFlow, AuthFlowBrowser)
+	setDefault(&c.TokenStore, TokenStoreFile)
+	setDefault(&c.TokenFile, ".twitch_tokens")
+	setDefault(&c.BotLogin, "shinybotwatch")
+	setDefault(&c.CommandsFile, "commands.json")
+	setDefault(&c.AuthURL, "https://id.twitch.tv/oauth2")
+	setDefault(&c.HelixURL, "https://api.twitch.tv/helix")
+	setDefault(&c.IRCURL, "wss://irc-ws.chat.twitch.tv")
+	setDefault(&c.EventSubURL, "wss://eventsub.wss.twitch.tv/ws")
+	if c.Subscriptions == nil {
+		c.Subscriptions = defaultSubscriptions
+	}
+
+	for _, name := range c.ChannelNames {
+		if _, ok := c.Channel(name); !ok {
+			c.Channels = append(c.Channels, ChannelConfig{Name: name})
+		}
+	}
+	if len(c.Channels) == 0 {
+		c.Channels = []ChannelConfig{{Name: "shinybucket_"}}
+	}
+	for i := range c.Channels {
+		channel := &c.Channels[i]
+		// IRC only accepts lowercase channel names
+		channel.Name = strings.ToLower(channel.Name)
+		setDefault(&channel.CommandsFile, c.CommandsFile)
+		if channel.Subscriptions == nil {
+			channel.Subscriptions = c.Subscriptions
+		}
+	}
+}
+
+func setDefault(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+func (c Config) validate() error {
+	if c.ClientId == "" {
+		return errors.New("twitch: missing client id (TWITCH_CLIENT_ID)")
+	}
+	switch c.AuthFlow {
+	case AuthFlowBrowser:
+		if c.ClientSecret == "" {
+			return errors.New("twitch: missing client secret (TWITCH_CLIENT_SECRET)")
+		}
+	case AuthFlowDevice:
+		// public clients have no secret
+	default:
+		return fmt.Errorf("twitch: unknown auth flow %q (TWITCH_AUTH_FLOW)", c.AuthFlow)
+	}
+	switch c.TokenStore {
+	case TokenStoreFile, TokenStoreKeyring:
+	case TokenStoreEnv:
+		if c.Tokens == "" {
+			return errors.New("twitch: missing tokens (TWITCH_TOKENS) for the env token store")
+		}
+	default:
+		return fmt.Errorf("twitch: unknown token store %q (TWITCH_TOKEN_STORE)", c.TokenStore)
+	}
+	for _, channel := range c.Channels {
+		if channel.Name == "" {
+			return errors.New("twitch: channel without a name")
+		}
+		for _, subscriptionType := range channel.Subscriptions {
+			if _, err := LookupSubscriptionType(subscriptionType); err != nil {
+				return fmt.Errorf("%w in channel %s", err, channel.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Channel returns the settings for the named channel.
+func (c Config) Channel(name string) (ChannelConfig, bool) {
+	for _, channel := range c.Channels {
+		if strings.EqualFold(channel.Name, name) {
+			return channel, true
+		}
+	}
+	return ChannelConfig{}, false
+}
+
+// JoinedChannels lists the names of every configured channel.
+func (c Config) JoinedChannels() []string {
+	names := make([]string, len(c.Channels))
+	for i, channel := range c.Channels {
+		names[i] = channel.Name
+	}
+	return names
+}
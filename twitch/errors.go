@@ -0,0 +1,13 @@
+package twitch
+
+import "errors"
+
+var (
+	ErrUnknownUser = errors.New("twitch: no matching users")
+	// ErrAuth means the bot could not be authorized, and retrying will not
+	// help without someone stepping in.
+	ErrAuth = errors.New("twitch: authorization failed")
+	// ErrPassphrase means the token file could not be decrypted.
+	ErrPassphrase    = errors.New("twitch: wrong or missing token file passphrase (TWITCH_TOKEN_PASSPHRASE)")
+	ErrReadOnlyStore = errors.New("twitch: token store is read-only")
+)
@@ -0,0 +1,370 @@
+package twitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRateLimitRetries bounds how many times a request is retried after 429
+// Too Many Requests before giving up.
+const maxRateLimitRetries = 5
+
+// Helix calls the Twitch API. It waits out rate limits: when a response says
+// no points remain, the next request waits until they are refilled, and a
+// 429 Too Many Requests is retried once Ratelimit-Reset has passed.
+// https://dev.twitch.tv/docs/api/guide/#twitch-rate-limits
+type Helix struct {
+	baseURL    string
+	clientId   string
+	httpClient *http.Client
+	// renew, when set, replaces a token Twitch rejected, after which the
+	// request is retried once
+	renew func(ctx context.Context) error
+
+	mu        sync.Mutex
+	remaining int
+	reset     time.Time
+}
+
+// NewHelix calls the API at baseURL (Config.HelixURL) with httpClient, which
+// should add the Authorization header, like the one from NewAuthClient.
+func NewHelix(baseURL string, clientId string, httpClient *http.Client) *Helix {
+	return &Helix{
+		baseURL:    baseURL,
+		clientId:   clientId,
+		httpClient: httpClient,
+		remaining:  -1,
+	}
+}
+
+// HelixError is an error response from the Twitch API.
+type HelixError struct {
+	Method     string `json:"-"`
+	Path       string `json:"-"`
+	StatusCode int    `json:"status"`
+	Status     string `json:"error"`
+	Message    string `json:"message"`
+}
+
+func (e *HelixError) Error() string {
+	return fmt.Sprintf("twitch: %s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, e.Status, e.Message)
+}
+
+// Temporary reports whether the same request may succeed later.
+func (e *HelixError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Pagination is the cursor for the next page of a paginated response.
+type Pagination struct {
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type helixResponse[T any] struct {
+	Data       []T        `json:"data"`
+	Pagination Pagination `json:"pagination"`
+	Total      int        `json:"total"`
+}
+
+// Do sends a request to path, such as "/users", with the given query and a
+// JSON body unless body is nil, and decodes the JSON response into out
+// unless out is nil. Error responses are returned as *HelixError, wrapped in
+// ErrAuth if the token is still rejected after renewing it.
+func (h *Helix) Do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	target := h.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	renewed := false
+	for attempt := 0; ; attempt++ {
+		if err := h.waitForPoints(ctx); err != nil {
+			return err
+		}
+		var bodyReader io.Reader
+		if payload != nil {
+			bodyReader = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target, bodyReader)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Client-Id", h.clientId)
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := h.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		h.updateRateLimit(resp.Header)
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < maxRateLimitRetries {
+			h.mu.Lock()
+			if h.remaining != 0 { // no Ratelimit headers to go by
+				h.remaining = 0
+				h.reset = time.Now().Add(time.Second)
+			}
+			h.mu.Unlock()
+			continue // waitForPoints waits until the reset
+		}
+		if resp.StatusCode == http.StatusUnauthorized && h.renew != nil && !renewed {
+			if err := h.renew(ctx); err != nil {
+				return err
+			}
+			renewed = true
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			helixErr := &HelixError{Status: http.StatusText(resp.StatusCode)}
+			_ = json.Unmarshal(data, helixErr)
+			helixErr.Method = method
+			helixErr.Path = path
+			helixErr.StatusCode = resp.StatusCode
+			if resp.StatusCode == http.StatusUnauthorized && renewed {
+				return fmt.Errorf("%w: %w", ErrAuth, helixErr)
+			}
+			return helixErr
+		}
+		if out == nil || len(data) == 0 {
+			return nil
+		}
+		return json.Unmarshal(data, out)
+	}
+}
+
+// updateRateLimit records the points left in the rate limit bucket.
+func (h *Helix) updateRateLimit(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("Ratelimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get("Ratelimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remaining = remaining
+	// Ratelimit-Reset is rounded down to the second, so waiting until then
+	// could be too early
+	h.reset = time.Unix(reset+1, 0)
+}
+
+// waitForPoints waits for the rate limit bucket to refill if it is empty,
+// then takes a point so that concurrent requests cannot overdraw it.
+func (h *Helix) waitForPoints(ctx context.Context) error {
+	for {
+		h.mu.Lock()
+		wait := time.Until(h.reset)
+		switch {
+		case h.remaining > 0:
+			h.remaining--
+			wait = 0
+		case h.remaining < 0:
+			wait = 0 // no Ratelimit headers yet
+		case wait <= 0:
+			h.remaining = -1 // refilled, but by how much is unknown
+		}
+		h.mu.Unlock()
+		if wait <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Iterator walks every item of a paginated endpoint, fetching pages as
+// needed:
+//
+//	it := helix.ChannelFollowers(broadcasterId)
+//	for it.Next(ctx) {
+//		follower := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+type Iterator[T any] struct {
+	helix *Helix
+	path  string
+	query url.Values
+
+	page    []T
+	current T
+	cursor  string
+	started bool
+	err     error
+}
+
+func newIterator[T any](h *Helix, path string, query url.Values) *Iterator[T] {
+	if query == nil {
+		query = url.Values{}
+	}
+	return &Iterator[T]{helix: h, path: path, query: query}
+}
+
+// Next advances to the next item, returning false when there are no more or
+// a request failed.
+func (it *Iterator[T]) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		if it.err != nil || (it.started && it.cursor == "") {
+			return false
+		}
+		query := url.Values{}
+		for key, values := range it.query {
+			query[key] = values
+		}
+		if it.cursor != "" {
+			query.Set("after", it.cursor)
+		}
+		var resp helixResponse[T]
+		if err := it.helix.Do(ctx, http.MethodGet, it.path, query, nil, &resp); err != nil {
+			it.err = err
+			return false
+		}
+		it.started = true
+		it.page = resp.Data
+		it.cursor = resp.Pagination.Cursor
+	}
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+func (it *Iterator[T]) Value() T {
+	return it.current
+}
+
+// Err is the error that stopped iteration, if any.
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// All collects every remaining item.
+func (it *Iterator[T]) All(ctx context.Context) ([]T, error) {
+	var all []T
+	for it.Next(ctx) {
+		all = append(all, it.Value())
+	}
+	return all, it.Err()
+}
+
+// GetUsers looks up users by login name.
+func (h *Helix) GetUsers(ctx context.Context, logins ...string) ([]User, error) {
+	var resp helixResponse[User]
+	err := h.Do(ctx, http.MethodGet, "/users", url.Values{"login": logins}, nil, &resp)
+	return resp.Data, err
+}
+
+// EventSubSubscription is a subscription as Helix reports it.
+type EventSubSubscription struct {
+	Id        string                `json:"id"`
+	Status    string                `json:"status"`
+	Type      string                `json:"type"`
+	Version   string                `json:"version"`
+	Condition SubscriptionCondition `json:"condition"`
+	Transport SubscriptionTransport `json:"transport"`
+	CreatedAt time.Time             `json:"created_at"`
+	Cost      int                   `json:"cost"`
+}
+
+func (h *Helix) CreateEventSubSubscription(ctx context.Context, sub Subscription) (EventSubSubscription, error) {
+	var resp helixResponse[EventSubSubscription]
+	if err := h.Do(ctx, http.MethodPost, "/eventsub/subscriptions", nil, sub, &resp); err != nil {
+		return EventSubSubscription{}, err
+	}
+	if len(resp.Data) == 0 {
+		return EventSubSubscription{}, fmt.Errorf("twitch: subscribing to %s returned no subscription", sub.Type)
+	}
+	return resp.Data[0], nil
+}
+
+// EventSubSubscriptions lists the app's subscriptions, optionally only those
+// with the given status or type.
+func (h *Helix) EventSubSubscriptions(status string, subscriptionType string) *Iterator[EventSubSubscription] {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+	if subscriptionType != "" {
+		query.Set("type", subscriptionType)
+	}
+	return newIterator[EventSubSubscription](h, "/eventsub/subscriptions", query)
+}
+
+func (h *Helix) DeleteEventSubSubscription(ctx context.Context, id string) error {
+	return h.Do(ctx, http.MethodDelete, "/eventsub/subscriptions", url.Values{"id": {id}}, nil, nil)
+}
+
+// Redemption is a channel points custom reward redemption.
+type Redemption struct {
+	Id                   string    `json:"id"`
+	BroadcasterUserId    string    `json:"broadcaster_id"`
+	BroadcasterUserLogin string    `json:"broadcaster_login"`
+	UserId               string    `json:"user_id"`
+	UserLogin            string    `json:"user_login"`
+	UserInput            string    `json:"user_input"`
+	Status               string    `json:"status"`
+	RedeemedAt           time.Time `json:"redeemed_at"`
+	Reward               struct {
+		Id    string `json:"id"`
+		Title string `json:"title"`
+		Cost  int    `json:"cost"`
+	} `json:"reward"`
+}
+
+// Redemptions lists a reward's redemptions with the given status, which
+// Twitch requires: UNFULFILLED, FULFILLED, or CANCELED.
+func (h *Helix) Redemptions(broadcasterId string, rewardId string, status string) *Iterator[Redemption] {
+	return newIterator[Redemption](h, "/channel_points/custom_rewards/redemptions", url.Values{
+		"broadcaster_id": {broadcasterId},
+		"reward_id":      {rewardId},
+		"status":         {status},
+	})
+}
+
+// UpdateRedemptionStatus marks redemptions FULFILLED or CANCELED.
+func (h *Helix) UpdateRedemptionStatus(ctx context.Context, broadcasterId string, rewardId string, status string, redemptionIds ...string) ([]Redemption, error) {
+	var resp helixResponse[Redemption]
+	err := h.Do(ctx, http.MethodPatch, "/channel_points/custom_rewards/redemptions", url.Values{
+		"broadcaster_id": {broadcasterId},
+		"reward_id":      {rewardId},
+		"id":             redemptionIds,
+	}, map[string]string{"status": status}, &resp)
+	return resp.Data, err
+}
+
+type Follower struct {
+	UserId     string    `json:"user_id"`
+	UserLogin  string    `json:"user_login"`
+	UserName   string    `json:"user_name"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// ChannelFollowers lists who follows a channel, newest first.
+func (h *Helix) ChannelFollowers(broadcasterId string) *Iterator[Follower] {
+	return newIterator[Follower](h, "/channels/followers", url.Values{"broadcaster_id": {broadcasterId}})
+}
@@ -0,0 +1,260 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitForPoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining int
+		// reset is when the bucket refills, from the start of the test
+		reset time.Duration
+		// timeout cancels the wait
+		timeout       time.Duration
+		wantWait      time.Duration
+		wantErr       error
+		wantRemaining int
+	}{
+		{
+			name:          "no rate limit headers yet",
+			remaining:     -1,
+			wantRemaining: -1,
+		},
+		{
+			name:          "points left",
+			remaining:     2,
+			reset:         time.Hour,
+			wantRemaining: 1,
+		},
+		{
+			name:          "empty bucket already refilled",
+			remaining:     0,
+			reset:         -time.Second,
+			wantRemaining: -1,
+		},
+		{
+			name:          "empty bucket",
+			remaining:     0,
+			reset:         200 * time.Millisecond,
+			wantWait:      200 * time.Millisecond,
+			wantRemaining: -1,
+		},
+		{
+			name:          "canceled while waiting",
+			remaining:     0,
+			reset:         time.Hour,
+			timeout:       50 * time.Millisecond,
+			wantWait:      50 * time.Millisecond,
+			wantErr:       context.DeadlineExceeded,
+			wantRemaining: 0,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+			start := time.Now()
+			h := &Helix{remaining: test.remaining, reset: start.Add(test.reset)}
+
+			err := h.waitForPoints(ctx)
+			waited := time.Since(start)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v, want %v", err, test.wantErr)
+			}
+			if waited < test.wantWait || waited > test.wantWait+time.Second {
+				t.Errorf("waited %v, want %v", waited, test.wantWait)
+			}
+			if h.remaining != test.wantRemaining {
+				t.Errorf("%d points remaining, want %d", h.remaining, test.wantRemaining)
+			}
+		})
+	}
+}
+
+func TestTooManyRequestsRetried(t *testing.T) {
+	tests := []struct {
+		name      string
+		throttled int
+		// headers sends the rate limit headers with each 429, saying the
+		// bucket has already refilled
+		headers      bool
+		wantRequests int
+		wantStatus   int
+	}{
+		{
+			name:         "once",
+			throttled:    1,
+			headers:      true,
+			wantRequests: 2,
+		},
+		{
+			name:         "without rate limit headers",
+			throttled:    1,
+			wantRequests: 2,
+		},
+		{
+			name:         "as often as retried",
+			throttled:    maxRateLimitRetries,
+			headers:      true,
+			wantRequests: maxRateLimitRetries + 1,
+		},
+		{
+			name:         "more often than retried",
+			throttled:    maxRateLimitRetries + 1,
+			headers:      true,
+			wantRequests: maxRateLimitRetries + 1,
+			wantStatus:   http.StatusTooManyRequests,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests++
+				throttled := requests <= test.throttled
+				mu.Unlock()
+				if !throttled {
+					w.Write([]byte(`{"data":[{"id":"1","login":"shinybucket_"}]}`))
+					return
+				}
+				if test.headers {
+					w.Header().Set("Ratelimit-Remaining", "0")
+					w.Header().Set("Ratelimit-Reset", strconv.FormatInt(time.Now().Unix()-1, 10))
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"error":"Too Many Requests","status":429,"message":"slow down"}`))
+			}))
+			defer server.Close()
+			h := NewHelix(server.URL, "client-id", server.Client())
+
+			users, err := h.GetUsers(context.Background(), "shinybucket_")
+			var helixErr *HelixError
+			switch {
+			case test.wantStatus == 0 && err != nil:
+				t.Errorf("got %v, want the users", err)
+			case test.wantStatus == 0 && (len(users) != 1 || users[0].Id != "1"):
+				t.Errorf("got users %+v, want shinybucket_", users)
+			case test.wantStatus != 0 && (!errors.As(err, &helixErr) || helixErr.StatusCode != test.wantStatus):
+				t.Errorf("got %v, want a %d HelixError", err, test.wantStatus)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if requests != test.wantRequests {
+				t.Errorf("sent %d requests, want %d", requests, test.wantRequests)
+			}
+		})
+	}
+}
+
+// testPage is a page of a paginated endpoint, and the cursor to the next.
+type testPage struct {
+	data   []string
+	cursor string
+}
+
+func TestIterator(t *testing.T) {
+	tests := []struct {
+		name string
+		// pages are keyed by the cursor that fetches them, "" for the first.
+		// Other cursors get an error.
+		pages       map[string]testPage
+		want        []string
+		wantErr     bool
+		wantCursors []string
+	}{
+		{
+			name:        "one page",
+			pages:       map[string]testPage{"": {data: []string{"a", "b"}}},
+			want:        []string{"a", "b"},
+			wantCursors: []string{""},
+		},
+		{
+			name:        "no items",
+			pages:       map[string]testPage{"": {}},
+			wantCursors: []string{""},
+		},
+		{
+			name: "several pages",
+			pages: map[string]testPage{
+				"":   {data: []string{"a", "b"}, cursor: "c1"},
+				"c1": {data: []string{"c"}, cursor: "c2"},
+				"c2": {data: []string{"d"}},
+			},
+			want:        []string{"a", "b", "c", "d"},
+			wantCursors: []string{"", "c1", "c2"},
+		},
+		{
+			name: "empty page with a cursor",
+			pages: map[string]testPage{
+				"":   {cursor: "c1"},
+				"c1": {data: []string{"a"}},
+			},
+			want:        []string{"a"},
+			wantCursors: []string{"", "c1"},
+		},
+		{
+			name: "failed page",
+			pages: map[string]testPage{
+				"": {data: []string{"a"}, cursor: "c1"},
+			},
+			want:        []string{"a"},
+			wantErr:     true,
+			wantCursors: []string{"", "c1"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var cursors []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cursor := r.URL.Query().Get("after")
+				cursors = append(cursors, cursor)
+				if r.URL.Query().Get("broadcaster_id") != "1" {
+					http.Error(w, `{"status":400,"message":"missing broadcaster_id"}`, http.StatusBadRequest)
+					return
+				}
+				page, ok := test.pages[cursor]
+				if !ok {
+					http.Error(w, `{"status":500,"message":"broken"}`, http.StatusInternalServerError)
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"data":       page.data,
+					"pagination": map[string]string{"cursor": page.cursor},
+				})
+			}))
+			defer server.Close()
+			h := NewHelix(server.URL, "client-id", server.Client())
+
+			got, err := newIterator[string](h, "/items", url.Values{"broadcaster_id": {"1"}}).All(context.Background())
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want one: %v", err, test.wantErr)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			if !slices.Equal(cursors, test.wantCursors) {
+				t.Errorf("fetched pages after %q, want %q", cursors, test.wantCursors)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package twitch
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spddl/go-twitch-ws"
+)
+
+// ircConn is the chat connection. go-twitch-ws reads its token without
+// locking whenever it logs in, including when it reconnects by itself, so a
+// refreshed token cannot be handed to a running client. Instead Reconnect
+// replaces the client with one logged in with the new token.
+type ircConn struct {
+	// dial logs in with token and sets up the handlers
+	dial   func(token string) (*twitch.Client, error)
+	logger *slog.Logger
+	// latest is the newest token, so that overlapping reconnects skip
+	// straight to it
+	latest atomic.Value
+	// mu guards client and is held while sending, as the library panics when
+	// sending on a closed client. Messages wait out a reconnect.
+	mu     sync.Mutex
+	client *twitch.Client
+	token  string
+}
+
+func newIRCConn(dial func(token string) (*twitch.Client, error), logger *slog.Logger) *ircConn {
+	return &ircConn{dial: dial, logger: logger}
+}
+
+// Connect logs in for the first time.
+func (c *ircConn) Connect(token string) error {
+	c.latest.Store(token)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	client, err := c.dial(token)
+	if err != nil {
+		return err
+	}
+	c.client, c.token = client, token
+	return nil
+}
+
+// Reconnect logs in again with token in the background. The old connection
+// is closed first, so no command is answered twice, and chat messages sent
+// until the new one has joined are missed. Before Connect it only records
+// the token.
+func (c *ircConn) Reconnect(token string) {
+	c.latest.Store(token)
+	go c.reconnect()
+}
+
+func (c *ircConn) reconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	token := c.latest.Load().(string)
+	if c.client == nil || token == c.token {
+		return
+	}
+	c.logger.Info("Reconnecting to chat with the refreshed token")
+	c.client.Close()
+	client, err := c.dial(token)
+	if err != nil {
+		c.logger.Error("Could not reconnect to chat", "err", err)
+		c.client = nil
+		return
+	}
+	c.client, c.token = client, token
+}
+
+// Say sends a message to channel, without the leading #, dropping it when
+// there is no connection.
+func (c *ircConn) Say(channel string, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		c.client.Say(channel, text, true)
+	}
+}
+
+func (c *ircConn) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		c.client.Close()
+		c.client = nil
+	}
+}
@@ -0,0 +1,67 @@
+package twitch
+
+import (
+	"fmt"
+
+	"github.com/kevinkjt2000/twitch-go-bot/eventsub"
+)
+
+// SubscriptionType describes how to subscribe to an EventSub type for a
+// broadcaster's channel.
+type SubscriptionType struct {
+	Version   string
+	Condition func(broadcasterId string) SubscriptionCondition
+}
+
+func broadcasterCondition(broadcasterId string) SubscriptionCondition {
+	return SubscriptionCondition{BroadcasterUserId: broadcasterId}
+}
+
+func moderatorCondition(broadcasterId string) SubscriptionCondition {
+	// The broadcaster is a moderator of their own channel
+	return SubscriptionCondition{BroadcasterUserId: broadcasterId, ModeratorUserId: broadcasterId}
+}
+
+// adBreakCondition is for channel.ad_break.begin, whose condition names the
+// broadcaster differently from the other types.
+func adBreakCondition(broadcasterId string) SubscriptionCondition {
+	return SubscriptionCondition{BroadcasterId: broadcasterId}
+}
+
+func raidCondition(broadcasterId string) SubscriptionCondition {
+	return SubscriptionCondition{ToBroadcasterUserId: broadcasterId}
+}
+
+// SubscriptionTypes lists the EventSub types the bot knows how to subscribe to.
+// https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types/
+var SubscriptionTypes = map[string]SubscriptionType{
+	eventsub.ChannelFollow:              {"2", moderatorCondition},
+	eventsub.ChannelSubscribe:           {"1", broadcasterCondition},
+	eventsub.ChannelSubscriptionMessage: {"1", broadcasterCondition},
+	eventsub.ChannelSubscriptionGift:    {"1", broadcasterCondition},
+	eventsub.ChannelCheer:               {"1", broadcasterCondition},
+	eventsub.ChannelRaid:                {"1", raidCondition},
+	eventsub.StreamOnline:               {"1", broadcasterCondition},
+	eventsub.StreamOffline:              {"1", broadcasterCondition},
+	eventsub.ChannelPollBegin:           {"1", broadcasterCondition},
+	eventsub.ChannelPollProgress:        {"1", broadcasterCondition},
+	eventsub.ChannelPollEnd:             {"1", broadcasterCondition},
+	eventsub.ChannelPredictionBegin:     {"1", broadcasterCondition},
+	eventsub.ChannelPredictionProgress:  {"1", broadcasterCondition},
+	eventsub.ChannelPredictionLock:      {"1", broadcasterCondition},
+	eventsub.ChannelPredictionEnd:       {"1", broadcasterCondition},
+	eventsub.ChannelHypeTrainBegin:      {"2", broadcasterCondition},
+	eventsub.ChannelHypeTrainProgress:   {"2", broadcasterCondition},
+	eventsub.ChannelHypeTrainEnd:        {"2", broadcasterCondition},
+	eventsub.ChannelAdBreakBegin:        {"1", adBreakCondition},
+	eventsub.ChannelPointsRedemptionAdd: {"1", broadcasterCondition},
+}
+
+// LookupSubscriptionType returns the known subscription type named name.
+func LookupSubscriptionType(name string) (SubscriptionType, error) {
+	subType, ok := SubscriptionTypes[name]
+	if !ok {
+		return SubscriptionType{}, fmt.Errorf("twitch: unknown subscription type %q", name)
+	}
+	return subType, nil
+}
@@ -0,0 +1,257 @@
+package twitch
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/oauth2"
+)
+
+// TokenStore keeps tokens by name, such as a Grant's.
+type TokenStore interface {
+	// Load returns the token saved as name, or nil if there is none.
+	Load(name string) (*oauth2.Token, error)
+	Save(name string, token *oauth2.Token) error
+}
+
+// readOnly reports whether store cannot save tokens, so new ones should not
+// be authorized.
+func readOnly(store TokenStore) bool {
+	_, ok := store.(*EnvStore)
+	return ok
+}
+
+// Places to keep tokens
+const (
+	// TokenStoreFile keeps tokens in Config.TokenFile, encrypted when
+	// Config.TokenPassphrase is set.
+	TokenStoreFile = "file"
+	// TokenStoreKeyring keeps tokens in the Secret Service keyring, such as
+	// GNOME Keyring or KeePassXC.
+	TokenStoreKeyring = "keyring"
+	// TokenStoreEnv reads tokens from Config.Tokens and cannot save them.
+	TokenStoreEnv = "env"
+)
+
+// keyringService is the service tokens are saved under in the keyring.
+const keyringService = "twitch-go-bot"
+
+// NewTokenStore returns the store conf.TokenStore names.
+func NewTokenStore(conf Config) (TokenStore, error) {
+	switch conf.TokenStore {
+	case TokenStoreFile:
+		return NewFileStore(conf.TokenFile, conf.TokenPassphrase), nil
+	case TokenStoreKeyring:
+		return keyringStore{}, nil
+	case TokenStoreEnv:
+		return NewEnvStore(conf.Tokens)
+	default:
+		return nil, fmt.Errorf("twitch: unknown token store %q (TWITCH_TOKEN_STORE)", conf.TokenStore)
+	}
+}
+
+// FileStore keeps every token in one JSON file, encrypted with AES-GCM
+// under a key derived from the passphrase when there is one.
+type FileStore struct {
+	path       string
+	passphrase string
+	// mu serializes updates, since each token refreshes on its own schedule
+	mu sync.Mutex
+}
+
+func NewFileStore(path string, passphrase string) *FileStore {
+	return &FileStore{path: path, passphrase: passphrase}
+}
+
+func (f *FileStore) Load(name string) (*oauth2.Token, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tokens, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	return tokens[name], nil
+}
+
+func (f *FileStore) Save(name string, token *oauth2.Token) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tokens, err := f.read()
+	if err != nil {
+		return err
+	}
+	tokens[name] = token
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if f.passphrase != "" {
+		if data, err = encryptTokens(data, f.passphrase); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(f.path, data); err != nil {
+		return fmt.Errorf("twitch: saving %s token: %w", name, err)
+	}
+	return nil
+}
+
+// read returns every token in the file. A plaintext file is still read when
+// there is a passphrase, and is encrypted the next time a token is saved.
+func (f *FileStore) read() (map[string]*oauth2.Token, error) {
+	tokens := map[string]*oauth2.Token{}
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var sealed encryptedTokens
+	if err := json.Unmarshal(data, &sealed); err == nil && sealed.Ciphertext != nil {
+		if f.passphrase == "" {
+			return nil, fmt.Errorf("%w: %s is encrypted", ErrPassphrase, f.path)
+		}
+		if data, err = sealed.decrypt(f.passphrase); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrPassphrase, f.path, err)
+		}
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("twitch: parsing %s: %w", f.path, err)
+	}
+	return tokens, nil
+}
+
+// writeFileAtomic replaces path so that a crash mid-write never leaves a
+// truncated file behind. Only the owner may read it.
+func writeFileAtomic(path string, data []byte) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// encryptedTokens is the token file when it is encrypted.
+type encryptedTokens struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func encryptTokens(plaintext []byte, passphrase string) ([]byte, error) {
+	sealed := encryptedTokens{Salt: make([]byte, 16)}
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := newTokenCipher(passphrase, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, err
+	}
+	sealed.Ciphertext = aead.Seal(nil, sealed.Nonce, plaintext, nil)
+	return json.MarshalIndent(sealed, "", "  ")
+}
+
+func (e encryptedTokens) decrypt(passphrase string) ([]byte, error) {
+	aead, err := newTokenCipher(passphrase, e.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Nonce) != aead.NonceSize() {
+		return nil, errors.New("bad nonce")
+	}
+	return aead.Open(nil, e.Nonce, e.Ciphertext, nil)
+}
+
+func newTokenCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	// scrypt parameters recommended for interactive logins as of 2017
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// keyringStore saves each token as its own Secret Service item.
+type keyringStore struct{}
+
+func (keyringStore) Load(name string) (*oauth2.Token, error) {
+	secret, err := keyring.Get(keyringService, name)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("twitch: loading %s token from keyring: %w", name, err)
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal([]byte(secret), &token); err != nil {
+		return nil, fmt.Errorf("twitch: parsing %s token from keyring: %w", name, err)
+	}
+	return &token, nil
+}
+
+func (keyringStore) Save(name string, token *oauth2.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	if err := keyring.Set(keyringService, name, string(data)); err != nil {
+		return fmt.Errorf("twitch: saving %s token to keyring: %w", name, err)
+	}
+	return nil
+}
+
+// EnvStore hands out tokens from a JSON object like the token file's, for
+// tokens managed outside the bot. Refreshed tokens are only kept in memory.
+type EnvStore struct {
+	tokens map[string]*oauth2.Token
+}
+
+func NewEnvStore(tokensJSON string) (*EnvStore, error) {
+	tokens := map[string]*oauth2.Token{}
+	if err := json.Unmarshal([]byte(tokensJSON), &tokens); err != nil {
+		return nil, fmt.Errorf("twitch: parsing TWITCH_TOKENS: %w", err)
+	}
+	return &EnvStore{tokens: tokens}, nil
+}
+
+// Load fails for missing tokens rather than returning nil, as authorizing a
+// new token would be wasted on a store that cannot save it.
+func (e *EnvStore) Load(name string) (*oauth2.Token, error) {
+	token, ok := e.tokens[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: TWITCH_TOKENS has no %q token", ErrReadOnlyStore, name)
+	}
+	return token, nil
+}
+
+func (e *EnvStore) Save(name string, token *oauth2.Token) error {
+	return fmt.Errorf("%w: cannot save %s token", ErrReadOnlyStore, name)
+}
@@ -0,0 +1,124 @@
+package twitch_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevinkjt2000/twitch-go-bot/twitch"
+	"golang.org/x/oauth2"
+)
+
+func testToken(accessToken string) *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  accessToken,
+		RefreshToken: accessToken + "-refresh",
+		TokenType:    "bearer",
+		Expiry:       time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	tests := []struct {
+		name string
+		// saved is the passphrase the file was written with, and whether
+		// there is a file at all
+		saved     string
+		noFile    bool
+		opened    string
+		wantErr   error
+		wantToken bool
+		// wantEncrypted is whether the file is encrypted once a token is
+		// saved with the passphrase it was opened with
+		wantEncrypted bool
+	}{
+		{
+			name:      "plaintext",
+			wantToken: true,
+		},
+		{
+			name:          "encrypted",
+			saved:         "hunter2",
+			opened:        "hunter2",
+			wantToken:     true,
+			wantEncrypted: true,
+		},
+		{
+			name:          "plaintext upgraded to encrypted",
+			opened:        "hunter2",
+			wantToken:     true,
+			wantEncrypted: true,
+		},
+		{
+			name:    "wrong passphrase",
+			saved:   "hunter2",
+			opened:  "hunter3",
+			wantErr: twitch.ErrPassphrase,
+		},
+		{
+			name:    "missing passphrase",
+			saved:   "hunter2",
+			wantErr: twitch.ErrPassphrase,
+		},
+		{
+			name:          "no file yet",
+			noFile:        true,
+			opened:        "hunter2",
+			wantEncrypted: true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel() // deriving keys is slow on purpose
+			path := filepath.Join(t.TempDir(), "tokens")
+			if !test.noFile {
+				if err := twitch.NewFileStore(path, test.saved).Save("bot", testToken("bot-token")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store := twitch.NewFileStore(path, test.opened)
+
+			token, err := store.Load("bot")
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr != nil {
+				return
+			}
+			if test.wantToken && (token == nil || *token != *testToken("bot-token")) {
+				t.Fatalf("loaded %+v, want %+v", token, testToken("bot-token"))
+			}
+			if !test.wantToken && token != nil {
+				t.Fatalf("loaded %+v, want no token", token)
+			}
+
+			if err := store.Save("broadcaster:shinybucket_", testToken("broadcaster-token")); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encrypted := !bytes.Contains(data, []byte("broadcaster-token")); encrypted != test.wantEncrypted {
+				t.Errorf("file encrypted: %v, want %v:\n%s", encrypted, test.wantEncrypted, data)
+			}
+			reopened := twitch.NewFileStore(path, test.opened)
+			for name, accessToken := range map[string]string{"bot": "bot-token", "broadcaster:shinybucket_": "broadcaster-token"} {
+				if name == "bot" && !test.wantToken {
+					continue
+				}
+				token, err := reopened.Load(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if token == nil || token.AccessToken != accessToken {
+					t.Errorf("loaded %+v as %s after saving, want %s", token, name, accessToken)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package twitchtest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kevinkjt2000/twitch-go-bot/internal"
+	"github.com/kevinkjt2000/twitch-go-bot/twitch"
+	"nhooyr.io/websocket"
+)
+
+type eventsubSession struct {
+	id   string
+	conn *websocket.Conn
+	// welcomed is closed once the session_welcome is sent, as nothing else
+	// may be sent before it, and closed is the connection ending
+	welcomed chan struct{}
+	closed   <-chan struct{}
+}
+
+func (s *Server) handleEventSub(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	ctx := conn.CloseRead(r.Context())
+
+	session := &eventsubSession{conn: conn, welcomed: make(chan struct{}), closed: ctx.Done()}
+	s.mu.Lock()
+	s.eventsubConns++
+	previous := s.sessions[r.URL.Query().Get("reconnect")]
+	if previous != nil {
+		session.id = previous.id
+	} else {
+		session.id, _ = internal.GenerateRandomStringURLSafe(16)
+	}
+	s.sessions[session.id] = session
+	s.mu.Unlock()
+
+	if err := s.send(ctx, session, "session_welcome", "", map[string]interface{}{
+		"session": s.sessionInfo(session, "connected", nil),
+	}); err != nil {
+		return
+	}
+	close(session.welcomed)
+	if previous != nil {
+		// Twitch drops the old connection once the new one is welcomed
+		previous.conn.Close(4004, "reconnected")
+	}
+
+	keepalive := time.Duration(s.KeepaliveTimeoutSeconds) * time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			if s.sessions[session.id] == session {
+				// Twitch disables a session's subscriptions when it ends
+				delete(s.sessions, session.id)
+				kept := s.subscriptions[:0]
+				for _, sub := range s.subscriptions {
+					if sub.Transport.SessionId != session.id {
+						kept = append(kept, sub)
+					}
+				}
+				s.subscriptions = kept
+			}
+			s.mu.Unlock()
+			return
+		case <-time.After(keepalive):
+			if !s.DisableKeepalives {
+				_ = s.send(ctx, session, "session_keepalive", "", map[string]interface{}{})
+			}
+		}
+	}
+}
+
+func (s *Server) sessionInfo(session *eventsubSession, status string, reconnectURL *string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                        session.id,
+		"status":                    status,
+		"connected_at":              time.Now().UTC(),
+		"keepalive_timeout_seconds": s.KeepaliveTimeoutSeconds,
+		"reconnect_url":             reconnectURL,
+	}
+}
+
+func (s *Server) send(ctx context.Context, session *eventsubSession, messageType string, subscriptionType string, payload interface{}) error {
+	if messageType != "session_welcome" {
+		select {
+		case <-session.welcomed:
+		case <-session.closed: // writing fails below
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	messageId, err := internal.GenerateRandomStringURLSafe(16)
+	if err != nil {
+		return err
+	}
+	metadata := map[string]interface{}{
+		"message_id":        messageId,
+		"message_type":      messageType,
+		"message_timestamp": time.Now().UTC(),
+	}
+	if subscriptionType != "" {
+		metadata["subscription_type"] = subscriptionType
+		metadata["subscription_version"] = "1"
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": metadata,
+		"payload":  payload,
+	})
+	if err != nil {
+		return err
+	}
+	return session.conn.Write(ctx, websocket.MessageText, data)
+}
+
+func (s *Server) allSessions() ([]*eventsubSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sessions) == 0 {
+		return nil, ErrNoSession
+	}
+	var sessions []*eventsubSession
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// findSubscription returns the subscription to subscriptionType that event is
+// for, going by its broadcaster, and the session it was created on. Without
+// a matching subscription, event goes to any session.
+func (s *Server) findSubscription(subscriptionType string, event interface{}) (twitch.Subscription, *eventsubSession, error) {
+	// events name the broadcaster the same way conditions do
+	var target twitch.SubscriptionCondition
+	if data, err := json.Marshal(event); err == nil {
+		_ = json.Unmarshal(data, &target)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subscriptions {
+		if sub.Type != subscriptionType {
+			continue
+		}
+		// channel.ad_break.begin names the broadcaster broadcaster_id in its
+		// condition, but broadcaster_user_id in its events
+		broadcasterId := sub.Condition.BroadcasterUserId
+		if broadcasterId == "" {
+			broadcasterId = sub.Condition.BroadcasterId
+		}
+		if target.BroadcasterUserId != "" && target.BroadcasterUserId != broadcasterId {
+			continue
+		}
+		if target.ToBroadcasterUserId != "" && target.ToBroadcasterUserId != sub.Condition.ToBroadcasterUserId {
+			continue
+		}
+		if session, ok := s.sessions[sub.Transport.SessionId]; ok {
+			return sub, session, nil
+		}
+	}
+	for _, session := range s.sessions {
+		return twitch.Subscription{Type: subscriptionType, Version: "1"}, session, nil
+	}
+	return twitch.Subscription{}, nil, ErrNoSession
+}
+
+// EventSubConnections counts the EventSub connections opened so far,
+// including ones made to follow a session_reconnect.
+func (s *Server) EventSubConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.eventsubConns
+}
+
+// SendKeepalive sends a session_keepalive message to every session.
+func (s *Server) SendKeepalive(ctx context.Context) error {
+	sessions, err := s.allSessions()
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := s.send(ctx, session, "session_keepalive", "", map[string]interface{}{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendNotification sends event as a notification for subscriptionType, on
+// the session subscribed to it for the event's broadcaster.
+func (s *Server) SendNotification(ctx context.Context, subscriptionType string, event interface{}) error {
+	sub, session, err := s.findSubscription(subscriptionType, event)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, session, "notification", subscriptionType, map[string]interface{}{
+		"subscription": sub,
+		"event":        event,
+	})
+}
+
+// SendRevocation revokes a subscription to subscriptionType with a reason
+// such as "authorization_revoked".
+func (s *Server) SendRevocation(ctx context.Context, subscriptionType string, status string) error {
+	sub, session, err := s.findSubscription(subscriptionType, nil)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for i, existing := range s.subscriptions {
+		if existing.Type == subscriptionType && existing.Transport.SessionId == sub.Transport.SessionId {
+			s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	return s.send(ctx, session, "revocation", subscriptionType, map[string]interface{}{
+		"subscription": map[string]interface{}{
+			"type":      sub.Type,
+			"version":   sub.Version,
+			"status":    status,
+			"condition": sub.Condition,
+			"transport": sub.Transport,
+		},
+	})
+}
+
+// SendReconnect asks the client to move every session to a new connection.
+func (s *Server) SendReconnect(ctx context.Context) error {
+	sessions, err := s.allSessions()
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		reconnectURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/eventsub?reconnect=" + session.id
+		if err := s.send(ctx, session, "session_reconnect", "", map[string]interface{}{
+			"session": s.sessionInfo(session, "reconnecting", &reconnectURL),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropEventSub abruptly closes every EventSub connection without a reconnect.
+func (s *Server) DropEventSub() error {
+	sessions, err := s.allSessions()
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := session.conn.Close(websocket.StatusGoingAway, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
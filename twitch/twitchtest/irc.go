@@ -0,0 +1,141 @@
+package twitchtest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+type ircConn struct {
+	conn *websocket.Conn
+	nick string
+	// channels the client joined, guarded by Server.mu
+	channels map[string]bool
+}
+
+func (c *ircConn) write(ctx context.Context, line string) error {
+	return c.conn.Write(ctx, websocket.MessageText, []byte(line+"\r\n"))
+}
+
+func (s *Server) handleIRC(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	client := &ircConn{conn: conn, channels: map[string]bool{}}
+	s.mu.Lock()
+	s.ircConns[client] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.ircConns, client)
+		s.mu.Unlock()
+	}()
+
+	ctx := r.Context()
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(data), "\r\n") {
+			if line != "" {
+				s.handleIRCLine(ctx, client, line)
+			}
+		}
+	}
+}
+
+func (s *Server) handleIRCLine(ctx context.Context, client *ircConn, line string) {
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	command, params, _ := strings.Cut(line, " ")
+	switch command {
+	case "CAP":
+		_ = client.write(ctx, ":tmi.twitch.tv CAP * ACK "+strings.TrimPrefix(params, "REQ "))
+	case "NICK":
+		client.nick = params
+		_ = client.write(ctx, fmt.Sprintf(":tmi.twitch.tv 001 %s :Welcome, GLHF!", client.nick))
+	case "JOIN":
+		for _, channel := range strings.Split(params, ",") {
+			_ = client.write(ctx, fmt.Sprintf(":%[1]s!%[1]s@%[1]s.tmi.twitch.tv JOIN %[2]s", client.nick, channel))
+			_ = client.write(ctx, s.userState(strings.TrimPrefix(channel, "#")))
+			s.mu.Lock()
+			client.channels[strings.TrimPrefix(channel, "#")] = true
+			s.mu.Unlock()
+		}
+	case "PING":
+		_ = client.write(ctx, "PONG :tmi.twitch.tv")
+	case "PRIVMSG":
+		channel, text, _ := strings.Cut(params, " :")
+		msg := ChatMessage{Channel: strings.TrimPrefix(channel, "#"), Text: text}
+		select {
+		case s.chat <- msg:
+		default: // nobody is reading chat, so drop it
+		}
+		_ = client.write(ctx, s.userState(msg.Channel))
+	}
+}
+
+// userState is what Twitch tells the bot about itself in channel after
+// joining or chatting.
+func (s *Server) userState(channel string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mods[channel] {
+		return fmt.Sprintf("@badges=moderator/1;mod=1 :tmi.twitch.tv USERSTATE #%s", channel)
+	}
+	return fmt.Sprintf("@badges=;mod=0 :tmi.twitch.tv USERSTATE #%s", channel)
+}
+
+// SetBotModerator makes the bot a moderator of channel, or not, from the
+// next USERSTATE on, which is sent after each message the bot sends.
+func (s *Server) SetBotModerator(channel string, mod bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mods[channel] = mod
+}
+
+// WaitForJoin waits until the bot has joined channel, after which it sees
+// chat sent there.
+func (s *Server) WaitForJoin(ctx context.Context, channel string) error {
+	for {
+		s.mu.Lock()
+		joined := false
+		for client := range s.ircConns {
+			joined = joined || client.channels[channel]
+		}
+		s.mu.Unlock()
+		if joined {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// SendChat delivers a chat message from user to the bot, with badges such as
+// "moderator" or "broadcaster".
+func (s *Server) SendChat(ctx context.Context, channel string, user string, text string, badges ...string) error {
+	for i, badge := range badges {
+		badges[i] = badge + "/1"
+	}
+	line := fmt.Sprintf("@badges=%[1]s;display-name=%[2]s;user-id=id-%[2]s :%[2]s!%[2]s@%[2]s.tmi.twitch.tv PRIVMSG #%[3]s :%[4]s",
+		strings.Join(badges, ","), user, channel, text)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.ircConns {
+		if err := client.write(ctx, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+// Package twitchtest provides a fake Twitch for offline end-to-end testing.
+//
+// A Server answers the OAuth, Helix, EventSub websocket, and IRC websocket
+// endpoints the bot talks to, and lets a test script what Twitch sends.
+// Point the bot at it with the twitch.Config returned by Server.Config.
+package twitchtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevinkjt2000/twitch-go-bot/internal"
+	"github.com/kevinkjt2000/twitch-go-bot/twitch"
+	"golang.org/x/oauth2"
+)
+
+var ErrNoSession = errors.New("twitchtest: no EventSub session connected")
+
+type Server struct {
+	*httptest.Server
+
+	// KeepaliveTimeoutSeconds is sent in session welcomes; keepalives are
+	// sent at this interval unless DisableKeepalives is set.
+	KeepaliveTimeoutSeconds int64
+	DisableKeepalives       bool
+
+	mu            sync.Mutex
+	users         map[string]twitch.User
+	subscriptions []twitch.Subscription
+	redemptions   map[string]string
+	sessions      map[string]*eventsubSession
+	eventsubConns int
+	ircConns      map[*ircConn]bool
+	mods          map[string]bool
+	chat          chan ChatMessage
+	throttle      int
+	devicePolls   int
+	// authorizers approve authorization requests in turn, the last one
+	// approving any after them
+	authorizers []string
+	// authorizations counts the authorization flows started
+	authorizations int
+	// what authorization and device codes, and tokens, were granted
+	codes         map[string]grant
+	accessTokens  map[string]grant
+	refreshTokens map[string]grant
+	invalidTokens map[string]bool
+}
+
+// grant is who authorized a code or token, and for which scopes.
+type grant struct {
+	login  string
+	scopes []string
+}
+
+// NewServer starts a fake Twitch. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		KeepaliveTimeoutSeconds: 10,
+		users:                   map[string]twitch.User{},
+		redemptions:             map[string]string{},
+		sessions:                map[string]*eventsubSession{},
+		codes:                   map[string]grant{},
+		accessTokens:            map[string]grant{},
+		refreshTokens:           map[string]grant{},
+		invalidTokens:           map[string]bool{},
+		ircConns:                map[*ircConn]bool{},
+		mods:                    map[string]bool{},
+		chat:                    make(chan ChatMessage, 100),
+		authorizers:             []string{botLogin},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/authorize", s.handleAuthorize)
+	mux.HandleFunc("/oauth2/device", s.handleDevice)
+	mux.HandleFunc("/oauth2/token", s.handleToken)
+	mux.HandleFunc("/oauth2/validate", s.handleValidate)
+	mux.HandleFunc("/helix/users", s.authorized(s.handleUsers))
+	mux.HandleFunc("/helix/eventsub/subscriptions", s.authorized(s.handleSubscriptions))
+	mux.HandleFunc("/helix/channel_points/custom_rewards/redemptions", s.authorized(s.handleRedemptions))
+	mux.HandleFunc("/eventsub", s.handleEventSub)
+	mux.HandleFunc("/irc", s.handleIRC)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Config returns a bot configuration that talks to this server.
+func (s *Server) Config() twitch.Config {
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http")
+	return twitch.Config{
+		ClientId:     "twitchtest-client-id",
+		ClientSecret: "twitchtest-client-secret",
+		BotLogin:     botLogin,
+		AuthURL:      s.URL + "/oauth2",
+		HelixURL:     s.URL + "/helix",
+		IRCURL:       wsURL + "/irc",
+		EventSubURL:  wsURL + "/eventsub",
+	}
+}
+
+// AddUser makes a user known to the Helix users endpoint.
+func (s *Server) AddUser(id string, login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[login] = twitch.User{Id: id, Login: login}
+}
+
+// Subscriptions returns every EventSub subscription created so far.
+func (s *Server) Subscriptions() []twitch.Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]twitch.Subscription(nil), s.subscriptions...)
+}
+
+// botLogin is the bot account in Config, which approves authorization
+// requests until AuthorizeAs is called.
+const botLogin = "twitchtest_bot"
+
+// AuthorizeAs makes logins approve the next authorization requests in turn,
+// as if each logged in to approve one, with the last approving any after
+// them. A bot authorizes its own token before its broadcasters' tokens.
+func (s *Server) AuthorizeAs(logins ...string) {
+	if len(logins) == 0 {
+		panic("twitchtest: AuthorizeAs needs a login")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorizers = logins
+}
+
+// nextAuthorizer returns the account approving an authorization request.
+func (s *Server) nextAuthorizer() string {
+	login := s.authorizers[0]
+	if len(s.authorizers) > 1 {
+		s.authorizers = s.authorizers[1:]
+	}
+	return login
+}
+
+// Authorizations counts the authorization code and device code flows
+// started so far.
+func (s *Server) Authorizations() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authorizations
+}
+
+// IssueToken returns a token for login with scopes, as if it was authorized
+// earlier.
+func (s *Server) IssueToken(login string, scopes ...string) *oauth2.Token {
+	if login == "" {
+		panic("twitchtest: IssueToken needs a login")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accessToken, refreshToken := s.issueToken(grant{login: login, scopes: scopes})
+	return &oauth2.Token{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "bearer",
+		Expiry:       time.Now().Add(tokenLifetime),
+	}
+}
+
+// tokenLifetime is how long access tokens last, as with Twitch's.
+const tokenLifetime = 4 * time.Hour
+
+func (s *Server) issueToken(g grant) (accessToken string, refreshToken string) {
+	accessToken, _ = internal.GenerateRandomString(30)
+	refreshToken, _ = internal.GenerateRandomString(50)
+	s.accessTokens[accessToken] = g
+	s.refreshTokens[refreshToken] = g
+	return accessToken, refreshToken
+}
+
+// Throttle answers the next n Helix requests with 429 Too Many Requests and
+// a rate limit that resets a second later.
+func (s *Server) Throttle(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttle = n
+}
+
+func (s *Server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if r.Header.Get("Client-Id") == "" || !ok {
+			writeError(w, http.StatusUnauthorized, "OAuth token is missing")
+			return
+		}
+		s.mu.Lock()
+		if s.invalidTokens[accessToken] {
+			s.mu.Unlock()
+			writeError(w, http.StatusUnauthorized, "Invalid OAuth token")
+			return
+		}
+		throttled := s.throttle > 0
+		if throttled {
+			s.throttle--
+		}
+		s.mu.Unlock()
+		w.Header().Set("Ratelimit-Limit", "800")
+		if throttled {
+			w.Header().Set("Ratelimit-Remaining", "0")
+			w.Header().Set("Ratelimit-Reset", strconv.FormatInt(time.Now().Add(time.Second).Unix(), 10))
+			writeError(w, http.StatusTooManyRequests, "Too Many Requests")
+			return
+		}
+		w.Header().Set("Ratelimit-Remaining", "799")
+		w.Header().Set("Ratelimit-Reset", strconv.FormatInt(time.Now().Unix(), 10))
+		handler(w, r)
+	}
+}
+
+// handleAuthorize skips the browser and immediately approves the request.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	code, _ := internal.GenerateRandomStringURLSafe(16)
+	s.mu.Lock()
+	s.codes[code] = grant{login: s.nextAuthorizer(), scopes: strings.Fields(query.Get("scope"))}
+	s.authorizations++
+	s.mu.Unlock()
+	redirect := query.Get("redirect_uri") + "?code=" + url.QueryEscape(code) + "&state=" + url.QueryEscape(query.Get("state"))
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// handleDevice starts a device code grant, which handleToken approves on
+// the second poll, as if someone entered the code in between.
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.devicePolls = 0
+	s.authorizations++
+	s.codes["twitchtest-device-code"] = grant{login: s.nextAuthorizer(), scopes: strings.Fields(r.FormValue("scopes"))}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"device_code":      "twitchtest-device-code",
+		"user_code":        "TWITCHTS",
+		"verification_uri": s.URL + "/activate?device-code=TWITCHTS",
+		"expires_in":       1800,
+		"interval":         1,
+	})
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("grant_type") == "urn:ietf:params:oauth:grant-type:device_code" {
+		s.mu.Lock()
+		s.devicePolls++
+		pending := s.devicePolls < 2
+		s.mu.Unlock()
+		if r.FormValue("device_code") != "twitchtest-device-code" {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": 400, "message": "invalid device code"})
+			return
+		}
+		if pending {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": 400, "message": "authorization_pending"})
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var g grant
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		g = s.codes[r.FormValue("code")]
+		delete(s.codes, r.FormValue("code"))
+	case "refresh_token":
+		if s.invalidTokens[r.FormValue("refresh_token")] {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": 400, "message": "Invalid refresh token"})
+			return
+		}
+		// refresh tokens the server did not issue are accepted without scopes,
+		// as the bot account's
+		var ok bool
+		if g, ok = s.refreshTokens[r.FormValue("refresh_token")]; !ok {
+			g = grant{login: botLogin}
+		}
+	default:
+		g = s.codes[r.FormValue("device_code")]
+	}
+	accessToken, refreshToken := s.issueToken(g)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(tokenLifetime.Seconds()),
+		"scope":         g.scopes,
+		"token_type":    "bearer",
+	})
+}
+
+// handleValidate describes tokens the server issued, and rejects any others.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	accessToken, _ := strings.CutPrefix(r.Header.Get("Authorization"), "OAuth ")
+	s.mu.Lock()
+	g, ok := s.accessTokens[accessToken]
+	ok = ok && !s.invalidTokens[accessToken]
+	user := s.users[g.login]
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"status": 401, "message": "invalid access token"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"client_id":  "twitchtest-client-id",
+		"login":      g.login,
+		"user_id":    user.Id,
+		"scopes":     append([]string{}, g.scopes...),
+		"expires_in": int(tokenLifetime.Seconds()),
+	})
+}
+
+// ExpireTokens invalidates every access token issued so far, as if they
+// expired early. Their refresh tokens still work.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token := range s.accessTokens {
+		s.invalidTokens[token] = true
+	}
+}
+
+// RevokeTokens invalidates every token issued so far, as if the user
+// disconnected the app, so the bot has to be authorized again.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token := range s.accessTokens {
+		s.invalidTokens[token] = true
+	}
+	for token := range s.refreshTokens {
+		s.invalidTokens[token] = true
+	}
+}
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users twitch.UsersData
+	for _, login := range r.URL.Query()["login"] {
+		if user, ok := s.users[login]; ok {
+			users.Data = append(users.Data, user)
+		}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (s *Server) handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		writePage(w, r, s.subscriptions)
+	case http.MethodPost:
+		var sub twitch.Subscription
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, ok := s.sessions[sub.Transport.SessionId]; !ok {
+			writeError(w, http.StatusBadRequest, "websocket transport session does not exist")
+			return
+		}
+		s.subscriptions = append(s.subscriptions, sub)
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"data": []twitch.Subscription{sub}})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" not supported")
+	}
+}
+
+func (s *Server) handleRedemptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" not supported")
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("id") == "" || query.Get("broadcaster_id") == "" || query.Get("reward_id") == "" {
+		writeError(w, http.StatusBadRequest, "missing id, broadcaster_id, or reward_id")
+		return
+	}
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Status != twitch.RedemptionFulfilled && body.Status != twitch.RedemptionCanceled {
+		writeError(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.redemptions[query.Get("id")] = body.Status
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": []map[string]string{{"id": query.Get("id"), "status": body.Status}},
+	})
+}
+
+// RedemptionStatus returns the status the bot gave a redemption, or "" if
+// it has not updated it.
+func (s *Server) RedemptionStatus(redemptionId string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.redemptions[redemptionId]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writePage writes the page of items that the first and after query
+// parameters ask for, with a cursor to the next page if there is one.
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	query := r.URL.Query()
+	first, err := strconv.Atoi(query.Get("first"))
+	if err != nil || first <= 0 {
+		first = 20
+	}
+	start := 0
+	if after := query.Get("after"); after != "" {
+		if start, err = strconv.Atoi(after); err != nil || start > len(items) {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+	}
+	end := start + first
+	if end > len(items) {
+		end = len(items)
+	}
+	pagination := map[string]string{}
+	if end < len(items) {
+		pagination["cursor"] = strconv.Itoa(end)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":       items[start:end],
+		"total":      len(items),
+		"pagination": pagination,
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error":   http.StatusText(status),
+		"status":  status,
+		"message": message,
+	})
+}
+
+// ChatMessage is a message the bot said in chat.
+type ChatMessage struct {
+	Channel string
+	Text    string
+}
+
+// NextChat waits for the bot to say something in chat.
+func (s *Server) NextChat(ctx context.Context) (ChatMessage, error) {
+	select {
+	case <-ctx.Done():
+		return ChatMessage{}, ctx.Err()
+	case msg := <-s.chat:
+		return msg, nil
+	}
+}